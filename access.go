@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Callers are identified by the role and orgID attributes of their
+// transaction certificate. A holder's orgID is its holder ID and a carrier's
+// orgID is its carrier ID.
+var roleAttribute = "role"
+var orgAttribute = "orgID"
+
+var holderRole = "holder"
+var carrierRole = "carrier"
+var adminRole = "admin"
+
+type Caller struct {
+	Role string
+	OrgID string
+}
+
+// accessRules lists the roles allowed to call each chaincode function.
+// Functions without an entry may not be called by anyone.
+var accessRules = map[string][]string{
+	"init": {adminRole},
+	"generatePolicy": {holderRole},
+	"assignTerms": {carrierRole},
+	"castVote": {carrierRole},
+	"modifyPolicy": {carrierRole},
+	"registerHolder": {adminRole},
+	"registerCarrier": {adminRole},
+	"setCarrierStatus": {adminRole},
+	"expirePolicies": {adminRole},
+	"renewPolicy": {holderRole},
+	"confirmTerms": {carrierRole},
+	"cancelPolicy": {holderRole, carrierRole, adminRole},
+	"endorsePolicy": {holderRole, adminRole},
+	"consentToModification": {holderRole},
+	"withdrawModification": {carrierRole, adminRole},
+	"fileClaim": {holderRole},
+	"adjudicateClaim": {carrierRole},
+	"recordPayout": {carrierRole},
+	"getIncompletePolicies": {carrierRole, adminRole},
+	"getPendingPolicies": {carrierRole, adminRole},
+	"getActivePolicies": {carrierRole, adminRole},
+	"listPolicies": {holderRole, carrierRole, adminRole},
+	"getExpiringPolicies": {carrierRole, adminRole},
+	"getPolicyChain": {holderRole, carrierRole, adminRole},
+	"getPolicy": {holderRole, carrierRole, adminRole},
+	"getOpenQuoteRequests": {carrierRole, adminRole},
+	"getPendingVotes": {carrierRole, adminRole},
+	"getHolder": {holderRole, carrierRole, adminRole},
+	"getPoliciesByHolder": {holderRole, carrierRole, adminRole},
+	"getCarriers": {holderRole, carrierRole, adminRole},
+	"getCarriersByCountry": {holderRole, carrierRole, adminRole},
+	"getPolicyHistory": {holderRole, carrierRole, adminRole},
+	"getPolicyVersion": {holderRole, carrierRole, adminRole},
+	"diffPolicyVersions": {holderRole, carrierRole, adminRole},
+	"getClaim": {holderRole, carrierRole, adminRole},
+	"getClaimsByPolicy": {holderRole, carrierRole, adminRole},
+	"getStateMachine": {holderRole, carrierRole, adminRole},
+	"getRequestSchema": {holderRole, carrierRole, adminRole},
+}
+
+func getCaller(stub LedgerStub) (Caller, error) {
+	fmt.Println("Function: getCaller")
+
+	var caller Caller
+	role, err := stub.ReadCertAttribute(roleAttribute)
+	if err != nil {
+		return caller, newError(codeUnauthorized, "Failed to read caller role: " + err.Error())
+	}
+	org, err := stub.ReadCertAttribute(orgAttribute)
+	if err != nil {
+		return caller, newError(codeUnauthorized, "Failed to read caller orgID: " + err.Error())
+	}
+
+	caller.Role = string(role)
+	caller.OrgID = string(org)
+	if caller.Role != holderRole && caller.Role != carrierRole && caller.Role != adminRole {
+		return caller, newError(codeUnauthorized, "Caller has unknown role: " + caller.Role).with("role", caller.Role)
+	}
+	if caller.OrgID == "" {
+		return caller, newError(codeUnauthorized, "Caller has no orgID")
+	}
+	return caller, nil
+}
+
+// authorize checks the caller's role against the access rule of function.
+func authorize(stub LedgerStub, function string) (Caller, error) {
+	fmt.Println("Function: authorize (" + function + ")")
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return caller, err
+	}
+
+	roles, found := accessRules[function]
+	if !found {
+		return caller, newError(codeUnknownFunction, "No access rule for function: " + function).with("function", function)
+	}
+
+	i := 0
+	for i < len(roles) {
+		if roles[i] == caller.Role {
+			return caller, nil
+		}
+		i = i + 1
+	}
+	return caller, newError(codeUnauthorized, "Caller with role " + caller.Role + " may not call " + function).with("role", caller.Role).with("function", function)
+}
+
+// checkActingAs rejects a caller with the given role who is acting on behalf
+// of an ID other than their own. Callers with other roles are not affected.
+func checkActingAs(stub LedgerStub, role string, id string) error {
+	fmt.Println("Function: checkActingAs")
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+
+	if caller.Role == role && caller.OrgID != id {
+		return newError(codeUnauthorized, "Caller " + caller.OrgID + " may not act as " + role + " " + id).with("caller", caller.OrgID).with(role, id)
+	}
+	return nil
+}
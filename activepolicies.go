@@ -1,204 +1,277 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	"strconv"
 )
 
-func addActivePolicy(stub *shim.ChaincodeStub, policy Policy) error {
-	fmt.Println("Function: addActivePolicy")
+func modifyActivePolicy(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: modifyActivePolicy")
 
-	i := 0
-	for i < len(policy.Votes) {
-		if policy.Votes[i].Vote != "approve" {
-			return errors.New("policy is not active; contains at least one vote that is not \"approve\"")
-		}
-		i = i + 1
+	request, err := parseTermsRequest(args)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("policy is confirmed to be active")
-	
-	activePolicies, err := readPolicies(stub, activePoliciesString)
+
+	carrierTerms := createTerms(stub.GetTxID(), request)
+	fmt.Println("new terms created successfully")
+
+	err = checkActingAs(stub, carrierRole, carrierTerms.CarrierID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	err = checkCarrierLicensed(stub, carrierTerms.CarrierID, carrierTerms.Country)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("active policies retrieved")
 	
-	i = 0
-	for i < len(activePolicies.Catalog) {
-		if activePolicies.Catalog[i].ID == policy.ID {
-			removePolicy(&activePolicies, i)
-			fmt.Println("active policy with matching ID has been found and removed")
-		}
-		i = i + 1
+	var policy Policy
+	policy, err = getPolicyByHash(stub, activeStage, request.PolicyID)
+	if err != nil {
+		return nil, err
 	}
+	fmt.Println("active policy successfully read")
 
-	activePolicies.Catalog = append(activePolicies.Catalog, policy)
-	fmt.Println("policy appended to active policies")
-
-	err = addPolicyToHolder(stub, policy, policy.HolderID)
-	err = writePolicies(stub, activePoliciesString, activePolicies)
+	err = checkExpectedVersion(policy, request.ExpectedVersion)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Println("active policies written with new active policy")
-
-	return nil
-}
 
-func addPolicyToHolder(stub *shim.ChaincodeStub, policy Policy, holderID string) error {
-	fmt.Println("Function: addPolicyToHolder")
+	err = checkNotLapsed(stub, policy)
+	if err != nil {
+		return nil, err
+	}
 
-	holdersAsBytes, err := stub.GetState(holdersString)
+	// An endorsement being quoted or voted on must be settled first
+	change, err := pendingChange(stub, policy.ID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if change != nil && change.Endorsement != nil {
+		return nil, newError(codeWrongStage, "Policy " + policy.ID + " has an endorsement in the " + change.Status + " stage").with("policyID", policy.ID).with("stage", change.Status)
 	}
-	fmt.Println("holders retrieved as bytes")
 
-	var holders AllHolders
-	err = json.Unmarshal(holdersAsBytes, &holders)
+	err = modifyPolicy(stub, policy, carrierTerms)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Println("holders retrieved from bytes")
+	fmt.Println("policy modified successfully")
+	
+	return nil, nil
+}
 
+func modifyPolicy(stub LedgerStub, policy Policy, terms CarrierTerms) error {
+	fmt.Println("Function: modifyPolicy")
+	
 	i := 0
-	var policyHolder PolicyHolder
-	for i < len(holders.Catalog) {
-		if holders.Catalog[i].ID == holderID {
-			policyHolder = holders.Catalog[i]
-			fmt.Println("policy holder found")
+	termsIndex := -1
+	for i < len(policy.Terms) {
+		if policy.Terms[i].CarrierID == terms.CarrierID && policy.Terms[i].Country == terms.Country {
+			termsIndex = i
 			break
 		}
 		i = i + 1
 	}
-	if policyHolder.ID == "" {
-		policyHolder.ID = holderID
-		tempPolicies := make([]Policy, 0)
-		policyHolder.Policies = tempPolicies
-		fmt.Println("new policy holder added")
+	if termsIndex == -1 {
+		return newError(codeConflictingTerms, "carrier " + terms.CarrierID + " not found for policy " + policy.ID + ", country of " + terms.Country).with("policyID", policy.ID).with("carrier", terms.CarrierID).with("country", terms.Country)
 	}
+	fmt.Println("terms to modify found")
 
-	i = 0
-	for i < len(policyHolder.Policies) {
-		if policyHolder.Policies[i].ID == policy.ID {
-			copy(policyHolder.Policies[:i], policyHolder.Policies[i + 1:])
-			policyHolder.Policies = policyHolder.Policies[:len(policyHolder.Policies) - 1]
-			fmt.Println("matching policy removed from policy holder")
-		}
-		i = i + 1
+	// A proposal must be approved or rejected before another is made
+	if policy.Modification != nil {
+		return newError(codeWrongStage, "Policy " + policy.ID + " has a modification pending").with("policyID", policy.ID).with("stage", activeStage)
 	}
 
-	policyHolder.Policies = append(policyHolder.Policies, policy)
-	fmt.Println("policy added to policy holder")
+	if policy.Terms[termsIndex].CancellationDate != "" {
+		return newError(codeConflictingTerms, "Terms for " + terms.Country + " were cancelled from " + policy.Terms[termsIndex].CancellationDate).with("policyID", policy.ID).with("country", terms.Country)
+	}
 
-	holdersAsBytes, err = json.Marshal(holders)
+	err := setTermsPeriod(stub, policy, &terms, &policy.Terms[termsIndex])
 	if err != nil {
 		return err
 	}
-	fmt.Println("holders written to bytes")
+	
+	existing := policy.Terms[termsIndex]
+	if terms.Premium == existing.Premium && terms.Value == existing.Value && terms.EffectiveDate == existing.EffectiveDate && terms.ExpiryDate == existing.ExpiryDate {
+		return newError(codeConflictingTerms, "terms submitted are not different than existing terms").with("policyID", policy.ID).with("country", terms.Country)
+	}
 
-	err = write(stub, holdersString, holdersAsBytes)
+	err = reserveTermsID(stub, terms.ID, policy.ID)
 	if err != nil {
 		return err
 	}
-	fmt.Println("holders written to blockchain with new policy")
-	
+
+	var modification Modification
+	modification.Terms = terms
+	modification.Votes = make([]Approval, len(policy.Terms))
+	modification.TxID = stub.GetTxID()
+	modification.Timestamp, err = txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	policy.Modification = &modification
+	fmt.Println("terms have been proposed")
+
+	queueEvent(stub, policyModifiedEvent, activeStage, policy, []string{terms.CarrierID}, []string{terms.Country})
+	_, err = applyTransition(stub, "modifyPolicy", policy.Terms, policy)
+	if err != nil {
+		return err
+	}
+	fmt.Println("active policy successfully written with proposed terms")
+
 	return nil
 }
 
-func modifyActivePolicy(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-	fmt.Println("Function: modifyActivePolicy")
+// consentToModification records the holder's vote on the terms proposed for
+// an active policy.
+func consentToModification(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: consentToModification")
 
-	if len(args) != 5 {
-		return nil, errors.New("Expected 5 arguments; arguments received: " + strconv.Itoa(len(args)))
+	request, err := parseConsentRequest(args)
+	if err != nil {
+		return nil, err
 	}
 
-	policyID := args[0]
-	carrierTerms, err := createTerms(args[1:])
+	err = checkActingAs(stub, holderRole, request.HolderID)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("new terms created successfully")
-	
-	var activePolicies AllPolicies
-	activePolicies, err = readPolicies(stub, activePoliciesString)
+
+	policy, err := getPolicyByHash(stub, activeStage, request.PolicyID)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("active policies successfully read")
+	if policy.HolderID != request.HolderID {
+		return nil, newError(codeUnauthorized, "Holder " + request.HolderID + " does not hold policy " + policy.ID).with("policyID", policy.ID).with("holder", request.HolderID)
+	}
 
-	var policyIndex int
-	policyIndex, err = getPolicyByHash(activePolicies.Catalog, policyID)
+	err = checkExpectedVersion(policy, request.ExpectedVersion)
 	if err != nil {
 		return nil, err
 	}
-	
+	if policy.Modification == nil {
+		return nil, newError(codeWrongStage, "Policy " + policy.ID + " has no modification pending").with("policyID", policy.ID).with("stage", activeStage)
+	}
+	if policy.Modification.HolderVote != "" {
+		return nil, newError(codeDuplicateVote, "vote has already been cast").with("policyID", policy.ID).with("holder", request.HolderID)
+	}
 
-	err = modifyPolicy(stub, activePolicies.Catalog[policyIndex], carrierTerms) 
+	err = checkNotLapsed(stub, policy)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("policy modified successfully")
-	
+
+	policy.Modification.HolderVote = request.Vote
+	if request.Vote == "disapprove" {
+		var rejection Rejection
+		rejection.HolderID = request.HolderID
+		rejection.Country = policy.Modification.Terms.Country
+		rejection.TermsID = policy.Modification.Terms.ID
+		rejection.Reason = request.Reason
+		rejection.TxID = stub.GetTxID()
+		rejection.Timestamp, err = txTimestamp(stub)
+		if err != nil {
+			return nil, err
+		}
+		policy.Rejections = append(policy.Rejections, rejection)
+	}
+
+	previousTerms := append([]CarrierTerms{}, policy.Terms...)
+	_, err = applyTransition(stub, "consentToModification", previousTerms, policy)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("holder vote recorded on policy " + policy.ID)
 	return nil, nil
 }
 
-func modifyPolicy(stub *shim.ChaincodeStub, policy Policy, terms CarrierTerms) error {
-	fmt.Println("Function: modifyPolicy")
-	
-	i := 0
-	termsIndex := -1
-	for i < len(policy.Terms) {
-		if policy.Terms[i].CarrierID == terms.CarrierID && policy.Terms[i].Country == terms.Country {
-			termsIndex = i
-			break
-		}
-		i = i + 1
+// withdrawModification drops the modification pending on an active policy.
+// Only the carrier that proposed it, or an admin, may withdraw it.
+func withdrawModification(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: withdrawModification")
+
+	request, err := parseWithdrawalRequest(args)
+	if err != nil {
+		return nil, err
 	}
-	if termsIndex == -1 {
-		return errors.New("carrier " + terms.CarrierID + " not found for policy " + policy.ID + ", country of " + terms.Country)
+
+	policy, err := getPolicyByHash(stub, activeStage, request.PolicyID)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("terms to modify found")
-	
-	if terms.ID == policy.Terms[termsIndex].ID {
-		return errors.New("terms submitted are not different than existing terms")
+	if policy.Modification == nil {
+		return nil, newError(codeWrongStage, "Policy " + policy.ID + " has no modification pending").with("policyID", policy.ID).with("stage", activeStage)
 	}
-	policy.Terms[termsIndex] = terms;
-	fmt.Println("terms have been modified")
 
-	pendingPolicies, err := readPolicies(stub, pendingPoliciesString)
+	carrierID := policy.Modification.Terms.CarrierID
+	err = checkActingAs(stub, carrierRole, carrierID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Println("pending policies have been retrieved")
 
-	i = 0
-	for i < len(pendingPolicies.Catalog) {
-		if pendingPolicies.Catalog[i].ID == policy.ID {
-			removePolicy(&pendingPolicies, i)
-			fmt.Println("policy has been found in pending policies & removed")
-			break
+	err = checkExpectedVersion(policy, request.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = applyTransition(stub, "withdrawModification", policy.Terms, policy)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("modification of policy " + policy.ID + " withdrawn")
+	return nil, nil
+}
+
+// applyModification replaces the terms of an approved modification, whose
+// votes become those of the policy.
+func applyModification(policy *Policy, date string) {
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].CarrierID == policy.Modification.Terms.CarrierID && policy.Terms[i].Country == policy.Modification.Terms.Country {
+			policy.Terms[i] = policy.Modification.Terms
 		}
 		i = i + 1
 	}
+	policy.Votes = policy.Modification.Votes
+	policy.Modification = nil
+}
 
-	i = 0
-	for i < len(policy.Votes){
-		policy.Votes[i].Vote = ""
+// discardModification drops a disapproved modification, leaving the policy on
+// its current terms.
+func discardModification(policy *Policy, date string) {
+	policy.Modification = nil
+}
+
+// modificationRejected holds once the holder or any carrier has disapproved
+// a modification, which the other votes can no longer save.
+func modificationRejected(policy Policy, date string) bool {
+	if policy.Modification == nil {
+		return false
+	}
+	if policy.Modification.HolderVote == "disapprove" {
+		return true
+	}
+	i := 0
+	for i < len(policy.Modification.Votes) {
+		if policy.Modification.Votes[i].Vote == "disapprove" {
+			return true
+		}
 		i = i + 1
 	}
+	return false
+}
 
-	pendingPolicies.Catalog = append(pendingPolicies.Catalog, policy)
-	fmt.Println("modified policy appended to pending policies")
-
-	err = writePolicies(stub, pendingPoliciesString, pendingPolicies)
-	if err != nil {
-		return err
+func modificationApproved(policy Policy, date string) bool {
+	if policy.Modification == nil || policy.Modification.HolderVote != "approve" {
+		return false
 	}
-	fmt.Println("pending policies successfully written with modified policy")
-	
-	return nil
+	i := 0
+	for i < len(policy.Modification.Votes) {
+		if policy.Modification.Votes[i].Vote != "approve" {
+			return false
+		}
+		i = i + 1
+	}
+	return true
 }
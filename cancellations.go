@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Cancelling terms sets their CancellationDate: they cover nothing from that
+// date on, and the carrier owes the premium for the days left in the terms
+// period. A policy whose every terms is cancelled stays active until the
+// latest of their cancellation dates, when it moves to the cancelled stage:
+// at once if that is the transaction date, or else through expirePolicies,
+// which finds it under policyCancellation~<cancellationDate>~<policyID>.
+var cancellationReasons = []string{"holderRequest", "nonPayment", "carrierWithdrawal", "subsidiaryClosed", "other"}
+
+var policyCancellationKeyPrefix = "policyCancellation~"
+
+func policyCancellationKey(cancellationDate string, policyID string) string {
+	return policyCancellationKeyPrefix + cancellationDate + "~" + policyID
+}
+
+func cancellationKeys(stage string, policy Policy) []string {
+	if stage != activeStage || len(policy.Terms) == 0 || !termsCancelled(policy) {
+		return []string{}
+	}
+	latest := ""
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].CancellationDate > latest {
+			latest = policy.Terms[i].CancellationDate
+		}
+		i = i + 1
+	}
+	return []string{policyCancellationKey(latest, policy.ID)}
+}
+
+// proRataRefund returns the part of the premium of terms for the days of
+// their period from the cancellation date on. Cancelling before the terms take
+// effect returns the whole premium.
+func proRataRefund(terms CarrierTerms, cancellationDate string) Refund {
+	var refund Refund
+	refund.CarrierID = terms.CarrierID
+	refund.Country = terms.Country
+	refund.TermsID = terms.ID
+	refund.Premium = terms.Premium
+
+	// Terms without a period are refunded in full
+	if terms.EffectiveDate == "" || terms.ExpiryDate == "" {
+		refund.Amount = terms.Premium
+		return refund
+	}
+
+	effective, _ := time.Parse(dateLayout, terms.EffectiveDate)
+	expiry, _ := time.Parse(dateLayout, terms.ExpiryDate)
+	cancellation, _ := time.Parse(dateLayout, cancellationDate)
+	if cancellation.Before(effective) {
+		cancellation = effective
+	}
+
+	day := 24 * time.Hour
+	refund.TotalDays = int64(expiry.Sub(effective) / day) + 1
+	refund.UnusedDays = int64(expiry.Sub(cancellation) / day) + 1
+	refund.Amount = terms.Premium * refund.UnusedDays / refund.TotalDays
+	return refund
+}
+
+func cancelPolicy(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: cancelPolicy")
+
+	request, err := parseCancelRequest(args)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := getPolicyByHash(stub, activeStage, request.PolicyID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkExpectedVersion(policy, request.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	// An approved change would restore the cancelled terms
+	change, err := pendingChange(stub, policy.ID)
+	if err != nil {
+		return nil, err
+	}
+	if change != nil {
+		return nil, newError(codeWrongStage, "Policy " + policy.ID + " has a change in the " + change.Status + " stage").with("policyID", policy.ID).with("stage", change.Status)
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = checkActingAs(stub, holderRole, policy.HolderID)
+	if err != nil {
+		return nil, err
+	}
+	if caller.Role == carrierRole && request.Country == "" {
+		return nil, newError(codeUnauthorized, "Carrier " + caller.OrgID + " may only cancel the terms for a country").with("caller", caller.OrgID)
+	}
+
+	today, err := txDate(stub)
+	if err != nil {
+		return nil, err
+	}
+	if request.EffectiveDate < today.Format(dateLayout) {
+		return nil, periodError("effectiveDate", "must not be before the transaction date " + today.Format(dateLayout))
+	}
+
+	var cancellation Cancellation
+	cancellation.Country = request.Country
+	cancellation.ReasonCode = request.ReasonCode
+	cancellation.EffectiveDate = request.EffectiveDate
+	cancellation.TxID = stub.GetTxID()
+	cancellation.Timestamp, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	cancellation.Refunds = make([]Refund, 0)
+
+	previousTerms := append([]CarrierTerms{}, policy.Terms...)
+	carriers := make([]string, 0)
+	countries := make([]string, 0)
+	i := 0
+	for i < len(policy.Terms) {
+		terms := policy.Terms[i]
+		if request.Country != "" && terms.Country != request.Country {
+			i = i + 1
+			continue
+		}
+		if terms.CancellationDate != "" {
+			if request.Country != "" {
+				return nil, newError(codeConflictingTerms, "Terms for " + terms.Country + " were cancelled from " + terms.CancellationDate).with("policyID", policy.ID).with("country", terms.Country)
+			}
+			i = i + 1
+			continue
+		}
+
+		err = checkActingAs(stub, carrierRole, terms.CarrierID)
+		if err != nil {
+			return nil, err
+		}
+		if terms.ExpiryDate != "" && request.EffectiveDate > terms.ExpiryDate {
+			return nil, periodError("effectiveDate", "must not be after the expiry date " + terms.ExpiryDate + " of the terms for " + terms.Country)
+		}
+
+		cancellation.Refunds = append(cancellation.Refunds, proRataRefund(terms, request.EffectiveDate))
+		policy.Terms[i].CancellationDate = request.EffectiveDate
+		carriers = append(carriers, terms.CarrierID)
+		countries = append(countries, terms.Country)
+		i = i + 1
+	}
+	if len(countries) == 0 {
+		if request.Country != "" {
+			return nil, newError(codeInvalidArgument, "Policy " + policy.ID + " has no terms for country: " + request.Country).with("policyID", policy.ID).with("country", request.Country)
+		}
+		return nil, newError(codeConflictingTerms, "Every terms of policy " + policy.ID + " has already been cancelled").with("policyID", policy.ID)
+	}
+
+	policy.Cancellations = append(policy.Cancellations, cancellation)
+	queueEvent(stub, termsCancelledEvent, activeStage, policy, carriers, countries)
+
+	// A modification of cancelled terms is withdrawn with them
+	if policy.Modification != nil {
+		i = 0
+		for i < len(countries) {
+			if countries[i] == policy.Modification.Terms.Country {
+				queueEvent(stub, modificationWithdrawnEvent, activeStage, policy, []string{policy.Modification.Terms.CarrierID}, []string{countries[i]})
+				policy.Modification = nil
+				break
+			}
+			i = i + 1
+		}
+	}
+
+	_, err = applyTransition(stub, "cancelPolicy", previousTerms, policy)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("terms cancelled on policy " + policy.ID)
+	return nil, nil
+}
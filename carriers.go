@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Each carrier is stored under carrier~<id>. The carriers licensed in a
+// country are indexed under carrierCountry~<country>~<carrierID>.
+var carrierKeyPrefix = "carrier~"
+var carrierCountryKeyPrefix = "carrierCountry~"
+
+var carrierActive = "active"
+var carrierSuspended = "suspended"
+
+func carrierKey(id string) string {
+	return carrierKeyPrefix + id
+}
+
+func carrierCountryKey(country string, carrierID string) string {
+	return carrierCountryKeyPrefix + country + "~" + carrierID
+}
+
+func registerCarrier(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: registerCarrier")
+
+	if len(args) < 3 {
+		return nil, argumentCountError("at least 3 arguments", len(args))
+	}
+
+	var carrier Carrier
+	carrier.ID = args[0]
+	carrier.Name = args[1]
+	carrier.LicensedCountries = args[2:]
+	carrier.Status = carrierActive
+
+	if carrier.ID == "" || carrier.Name == "" {
+		return nil, newError(codeInvalidArgument, "Carrier ID and name are required")
+	}
+	err := checkKeyPart("carrier", carrier.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	carrierAsBytes, err := stub.GetState(carrierKey(carrier.ID))
+	if err != nil {
+		return nil, err
+	}
+	if carrierAsBytes != nil {
+		return nil, newError(codeAlreadyExists, "Carrier already registered with ID: " + carrier.ID).with("carrier", carrier.ID)
+	}
+
+	i := 0
+	for i < len(carrier.LicensedCountries) {
+		if carrier.LicensedCountries[i] == "" {
+			return nil, newError(codeInvalidArgument, "Licensed countries must not be empty")
+		}
+		err = checkKeyPart("licensedCountries", carrier.LicensedCountries[i])
+		if err != nil {
+			return nil, err
+		}
+		err = write(stub, carrierCountryKey(carrier.LicensedCountries[i], carrier.ID), []byte(carrier.ID))
+		if err != nil {
+			return nil, err
+		}
+		i = i + 1
+	}
+
+	err = writeCarrier(stub, carrier)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("carrier " + carrier.ID + " registered")
+	return nil, nil
+}
+
+func setCarrierStatus(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: setCarrierStatus")
+
+	if len(args) != 2 {
+		return nil, argumentCountError("2 arguments", len(args))
+	}
+
+	status := args[1]
+	if status != carrierActive && status != carrierSuspended {
+		return nil, newError(codeInvalidArgument, "Invalid carrier status: " + status).with("status", status)
+	}
+
+	carrier, err := readCarrier(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	carrier.Status = status
+	err = writeCarrier(stub, carrier)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("carrier " + carrier.ID + " is now " + status)
+	return nil, nil
+}
+
+func writeCarrier(stub LedgerStub, carrier Carrier) error {
+	fmt.Println("Function: writeCarrier")
+
+	carrierAsBytes, err := json.Marshal(carrier)
+	if err != nil {
+		return err
+	}
+
+	return write(stub, carrierKey(carrier.ID), carrierAsBytes)
+}
+
+func readCarrier(stub LedgerStub, carrierID string) (Carrier, error) {
+	fmt.Println("Function: readCarrier")
+
+	var carrier Carrier
+	carrierAsBytes, err := stub.GetState(carrierKey(carrierID))
+	if err != nil {
+		return carrier, err
+	}
+	if carrierAsBytes == nil {
+		return carrier, newError(codeNotFound, "No carrier registered with ID: " + carrierID).with("carrier", carrierID)
+	}
+
+	err = json.Unmarshal(carrierAsBytes, &carrier)
+	return carrier, err
+}
+
+// checkCarrierLicensed rejects a carrier that is unregistered, suspended, or
+// not licensed to write terms in country.
+func checkCarrierLicensed(stub LedgerStub, carrierID string, country string) error {
+	fmt.Println("Function: checkCarrierLicensed")
+
+	carrier, err := readCarrier(stub, carrierID)
+	if err != nil {
+		return err
+	}
+
+	if carrier.Status != carrierActive {
+		return newError(codeCarrierNotEligible, "Carrier " + carrierID + " is " + carrier.Status).with("carrier", carrierID).with("status", carrier.Status)
+	}
+
+	i := 0
+	for i < len(carrier.LicensedCountries) {
+		if carrier.LicensedCountries[i] == country {
+			return nil
+		}
+		i = i + 1
+	}
+	return newError(codeCarrierNotEligible, "Carrier " + carrierID + " is not licensed in country: " + country).with("carrier", carrierID).with("country", country)
+}
+
+func getCarriers(stub LedgerStub) ([]byte, error) {
+	fmt.Println("Function: getCarriers")
+
+	var carriers AllCarriers
+	carriers.Catalog = make([]Carrier, 0)
+
+	startKey, endKey := prefixRange(carrierKeyPrefix)
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, carrierAsBytes, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var carrier Carrier
+		err = json.Unmarshal(carrierAsBytes, &carrier)
+		if err != nil {
+			return nil, err
+		}
+		carriers.Catalog = append(carriers.Catalog, carrier)
+	}
+
+	return json.Marshal(carriers)
+}
+
+func getCarriersByCountry(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getCarriersByCountry")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	var carriers AllCarriers
+	carriers.Catalog = make([]Carrier, 0)
+
+	startKey, endKey := prefixRange(carrierCountryKey(args[0], ""))
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, carrierID, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		carrier, err := readCarrier(stub, string(carrierID))
+		if err != nil {
+			return nil, err
+		}
+		carriers.Catalog = append(carriers.Catalog, carrier)
+	}
+
+	return json.Marshal(carriers)
+}
@@ -0,0 +1,1156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLedger returns an initialized ledger with one holder, acme, and two
+// carriers: carrierA, licensed in US and FR, and carrierB, licensed in DE.
+func newTestLedger(t *testing.T) *memoryLedger {
+	l := newMemoryLedger()
+	l.as(adminRole, "admin")
+	mustSucceed(t)(l.init())
+	mustSucceed(t)(l.invoke("registerHolder", "acme", "Acme Corp", "US", "risk@acme.example"))
+	mustSucceed(t)(l.invoke("registerCarrier", "carrierA", "Carrier A", "US", "FR"))
+	mustSucceed(t)(l.invoke("registerCarrier", "carrierB", "Carrier B", "DE"))
+	return l
+}
+
+func mustSucceed(t *testing.T) func([]byte, error) []byte {
+	return func(result []byte, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result
+	}
+}
+
+// readStage returns the policies listed by one of the get*Policies queries.
+func readStage(t *testing.T, l *memoryLedger, function string) []Policy {
+	t.Helper()
+	l.as(adminRole, "admin")
+	var policies AllPolicies
+	err := json.Unmarshal(mustSucceed(t)(l.query(function)), &policies)
+	if err != nil {
+		t.Fatalf("unmarshal %s: %v", function, err)
+	}
+	return policies.Catalog
+}
+
+func onlyPolicy(t *testing.T, l *memoryLedger, function string) Policy {
+	t.Helper()
+	policies := readStage(t, l, function)
+	if len(policies) != 1 {
+		t.Fatalf("%s returned %d policies, want 1", function, len(policies))
+	}
+	return policies[0]
+}
+
+// incompletePolicy generates a US and DE policy for acme.
+func incompletePolicy(t *testing.T, l *memoryLedger) Policy {
+	t.Helper()
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("generatePolicy", "acme", "US", "DE"))
+	return onlyPolicy(t, l, "getIncompletePolicies")
+}
+
+// pendingPolicy generates a policy and has both carriers quote on it.
+func pendingPolicy(t *testing.T, l *memoryLedger) Policy {
+	t.Helper()
+	policy := incompletePolicy(t, l)
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "US", "100", "1000"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("assignTerms", policy.ID, "carrierB", "DE", "200", "2000"))
+	return onlyPolicy(t, l, "getPendingPolicies")
+}
+
+// activePolicy takes a pending policy through approval by both carriers.
+func activePolicy(t *testing.T, l *memoryLedger) Policy {
+	t.Helper()
+	policy := pendingPolicy(t, l)
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+	return onlyPolicy(t, l, "getActivePolicies")
+}
+
+func lastEvents(t *testing.T, l *memoryLedger) []PolicyEvent {
+	t.Helper()
+	event, found := l.events[l.GetTxID()]
+	if !found {
+		t.Fatalf("no event set by %s", l.GetTxID())
+	}
+	var batch PolicyEvents
+	err := json.Unmarshal(event.payload, &batch)
+	if err != nil {
+		t.Fatalf("unmarshal event: %v", err)
+	}
+	return batch.Events
+}
+
+func TestPolicyLifecycle(t *testing.T) {
+	l := newTestLedger(t)
+
+	policy := incompletePolicy(t, l)
+	if policy.HolderID != "acme" || len(policy.Terms) != 2 {
+		t.Fatalf("unexpected incomplete policy: %+v", policy)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "US", "100", "1000"))
+	if len(readStage(t, l, "getPendingPolicies")) != 0 {
+		t.Fatal("policy with unquoted country moved to pending")
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("assignTerms", policy.ID, "carrierB", "DE", "200", "2000"))
+	if len(readStage(t, l, "getIncompletePolicies")) != 0 {
+		t.Fatal("complete policy left in incomplete policies")
+	}
+	pending := onlyPolicy(t, l, "getPendingPolicies")
+	if pending.ID != policy.ID || len(pending.Votes) != 2 || pending.Status != pendingStage {
+		t.Fatalf("unexpected pending policy: %+v", pending)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve"))
+	if len(readStage(t, l, "getActivePolicies")) != 0 {
+		t.Fatal("policy activated before every carrier voted")
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+	events := lastEvents(t, l)
+	if events[len(events) - 1].Type != policyActivatedEvent {
+		t.Fatalf("last event %q, want %q", events[len(events) - 1].Type, policyActivatedEvent)
+	}
+	if len(readStage(t, l, "getPendingPolicies")) != 0 {
+		t.Fatal("activated policy left in pending policies")
+	}
+	active := onlyPolicy(t, l, "getActivePolicies")
+	if active.ID != policy.ID || active.Status != activeStage || active.Terms[0].Premium != 100 || active.Terms[1].Value != 2000 {
+		t.Fatalf("unexpected active policy: %+v", active)
+	}
+
+	var holderPolicies AllPolicies
+	err := json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getPoliciesByHolder", "acme")), &holderPolicies)
+	if err != nil || len(holderPolicies.Catalog) != 1 {
+		t.Fatalf("getPoliciesByHolder returned %+v, %v", holderPolicies, err)
+	}
+
+	var history HistoryPage
+	err = json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getPolicyHistory", policy.ID)), &history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stages := make([]string, 0)
+	for _, entry := range history.Entries {
+		stages = append(stages, entry.PreviousStage + ">" + entry.NewStage)
+	}
+	want := ">incomplete incomplete>incomplete incomplete>pending pending>pending pending>active"
+	if strings.Join(stages, " ") != want {
+		t.Fatalf("history stages %q, want %q", strings.Join(stages, " "), want)
+	}
+}
+
+func TestDisapprovalReopensRejectedTerms(t *testing.T) {
+	l := newTestLedger(t)
+	policy := pendingPolicy(t, l)
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "disapprove", "premium too low for the risk"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+
+	if len(readStage(t, l, "getPendingPolicies")) != 0 || len(readStage(t, l, "getActivePolicies")) != 0 {
+		t.Fatal("disapproved policy was not removed from pending")
+	}
+	reopened := onlyPolicy(t, l, "getIncompletePolicies")
+	if reopened.ID != policy.ID {
+		t.Fatal("disapproved policy reopened under a new ID")
+	}
+	if reopened.Terms[0].ID != "" || reopened.Terms[0].Country != "US" || reopened.Terms[1].ID != policy.Terms[1].ID {
+		t.Fatalf("unexpected terms after disapproval: %+v", reopened.Terms)
+	}
+	if len(reopened.Rejections) != 1 || reopened.Rejections[0].CarrierID != "carrierA" || reopened.Rejections[0].TermsID != policy.Terms[0].ID || reopened.Rejections[0].Reason != "premium too low for the risk" {
+		t.Fatalf("unexpected rejections: %+v", reopened.Rejections)
+	}
+
+	var history HistoryPage
+	err := json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getPolicyHistory", policy.ID)), &history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejection := history.Entries[len(history.Entries) - 1]
+	if rejection.NewStage != incompleteStage || len(rejection.TermsDiff) != 1 || rejection.TermsDiff[0].Previous == nil || rejection.TermsDiff[0].Previous.ID != policy.Terms[0].ID || rejection.TermsDiff[0].Current != nil {
+		t.Fatalf("rejected terms missing from history: %+v", rejection)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "US", "150", "1000"))
+	pending := onlyPolicy(t, l, "getPendingPolicies")
+	if pending.Votes[1].Vote != "approve" {
+		t.Fatalf("approval of kept terms was not retained: %+v", pending.Votes)
+	}
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve"))
+	if onlyPolicy(t, l, "getActivePolicies").Terms[0].Premium != 150 {
+		t.Fatal("requoted policy was not activated")
+	}
+}
+
+func TestModifyPolicy(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("modifyPolicy", policy.ID, "carrierA", "US", "150", "1000"))
+
+	active := onlyPolicy(t, l, "getActivePolicies")
+	if active.Terms[0].Premium != 100 || active.Modification == nil {
+		t.Fatalf("active policy changed before the modification was approved: %+v", active)
+	}
+	if active.Modification.Terms.Premium != 150 || active.Modification.Terms.ID == policy.Terms[0].ID {
+		t.Fatalf("unexpected modification: %+v", active.Modification)
+	}
+	for _, vote := range active.Modification.Votes {
+		if vote.Vote != "" {
+			t.Fatalf("votes not reset on modification: %+v", active.Modification.Votes)
+		}
+	}
+	if len(readStage(t, l, "getPendingPolicies")) != 0 {
+		t.Fatal("modified policy duplicated in the pending stage")
+	}
+	_, err := l.as(carrierRole, "carrierB").invoke("modifyPolicy", policy.ID, "carrierB", "DE", "250", "2000")
+	if err == nil || asChaincodeError(err).Code != codeWrongStage {
+		t.Fatalf("got error %v, want %s", err, codeWrongStage)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+	var detail PolicyDetail
+	err = json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getPolicy", policy.ID)), &detail)
+	if err != nil || strings.Join(detail.OutstandingVoters, ",") != "acme" || detail.Policy.Terms[0].Premium != 100 {
+		t.Fatalf("modification approved before the holder consented: %+v, %v", detail, err)
+	}
+
+	mustSucceed(t)(l.invoke("consentToModification", policy.ID, "acme", "approve"))
+	active = onlyPolicy(t, l, "getActivePolicies")
+	if active.Terms[0].Premium != 150 || active.Modification != nil || active.Votes[0].Vote != "approve" {
+		t.Fatalf("approved modification not made active: %+v", active)
+	}
+
+	var history HistoryPage
+	err = json.Unmarshal(mustSucceed(t)(l.query("getPolicyHistory", policy.ID)), &history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approval := history.Entries[len(history.Entries) - 1]
+	if len(approval.TermsDiff) != 1 || approval.TermsDiff[0].Previous.Premium != 100 || approval.TermsDiff[0].Current.Premium != 150 {
+		t.Fatalf("replaced terms missing from history: %+v", approval)
+	}
+}
+
+func TestHolderRejectsModification(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("modifyPolicy", policy.ID, "carrierA", "US", "300", "1000"))
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("consentToModification", policy.ID, "acme", "disapprove", "too expensive"))
+	if events := lastEvents(t, l); events[len(events) - 1].Type != modificationRejectedEvent {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	active := onlyPolicy(t, l, "getActivePolicies")
+	if active.Terms[0].Premium != 100 || active.Modification != nil {
+		t.Fatalf("rejected modification not discarded: %+v", active)
+	}
+	if len(active.Rejections) != 1 || active.Rejections[0].HolderID != "acme" || active.Rejections[0].Reason != "too expensive" {
+		t.Fatalf("unexpected rejections: %+v", active.Rejections)
+	}
+	_, err := l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve")
+	if err == nil || asChaincodeError(err).Code != codeWrongStage {
+		t.Fatalf("got error %v, want %s", err, codeWrongStage)
+	}
+}
+
+func TestCarrierRejectsModification(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	// A suspended carrier cannot vote, which must not hold up a rejection
+	mustSucceed(t)(l.as(adminRole, "admin").invoke("setCarrierStatus", "carrierB", carrierSuspended))
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("modifyPolicy", policy.ID, "carrierA", "US", "300", "1000"))
+	mustSucceed(t)(l.invoke("castVote", policy.ID, "carrierA", "disapprove", "priced in error"))
+	if active := onlyPolicy(t, l, "getActivePolicies"); active.Modification != nil || active.Terms[0].Premium != 100 {
+		t.Fatalf("rejected modification not discarded: %+v", active)
+	}
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("endorsePolicy", policy.ID, "addCountry", "FR"))
+}
+
+func TestWithdrawModification(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("modifyPolicy", policy.ID, "carrierA", "US", "300", "1000"))
+	_, err := l.as(carrierRole, "carrierB").invoke("withdrawModification", policy.ID)
+	if err == nil || asChaincodeError(err).Code != codeUnauthorized {
+		t.Fatalf("got error %v, want %s", err, codeUnauthorized)
+	}
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("withdrawModification", policy.ID))
+	if events := lastEvents(t, l); len(events) != 1 || events[0].Type != modificationWithdrawnEvent {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if active := onlyPolicy(t, l, "getActivePolicies"); active.Modification != nil {
+		t.Fatalf("withdrawn modification still pending: %+v", active)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("modifyPolicy", policy.ID, "carrierA", "US", "300", "1000"))
+	mustSucceed(t)(l.as(adminRole, "admin").invoke("withdrawModification", policy.ID))
+
+	// Cancelling the modified terms withdraws a modification of them
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("modifyPolicy", policy.ID, "carrierA", "US", "300", "1000"))
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("cancelPolicy", policy.ID, "holderRequest", "2017-03-01", "US"))
+	if active := onlyPolicy(t, l, "getActivePolicies"); active.Modification != nil || active.Terms[0].CancellationDate != "2017-03-01" {
+		t.Fatalf("modification of cancelled terms still pending: %+v", active)
+	}
+}
+
+func TestPolicyVersions(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+	if policy.Version != 5 {
+		t.Fatalf("active policy at version %d, want 5", policy.Version)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("modifyPolicy", policy.ID, "carrierA", "US", "150", "1000"))
+	mustSucceed(t)(l.invoke("castVote", policy.ID, "carrierA", "approve"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("consentToModification", policy.ID, "acme", "approve"))
+	if active := onlyPolicy(t, l, "getActivePolicies"); active.Version != 9 {
+		t.Fatalf("modified policy at version %d, want 9", active.Version)
+	}
+
+	var previous Policy
+	err := json.Unmarshal(mustSucceed(t)(l.query("getPolicyVersion", policy.ID, "5")), &previous)
+	if err != nil || previous.Version != 5 || previous.Terms[0].Premium != 100 || previous.Modification != nil {
+		t.Fatalf("unexpected version 5: %+v, %v", previous, err)
+	}
+
+	var diff VersionDiff
+	err = json.Unmarshal(mustSucceed(t)(l.query("diffPolicyVersions", policy.ID, "5", "9")), &diff)
+	if err != nil || len(diff.Countries) != 1 {
+		t.Fatalf("unexpected diff: %+v, %v", diff, err)
+	}
+	change := diff.Countries[0]
+	if change.Country != "US" || change.Previous.Premium != 100 || change.Current.Premium != 150 || change.Current.CarrierID != "carrierA" {
+		t.Fatalf("unexpected change: %+v", change)
+	}
+}
+
+func TestExpectedVersion(t *testing.T) {
+	l := newTestLedger(t)
+	policy := incompletePolicy(t, l)
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", `{"policyID": "` + policy.ID + `", "carrier": "carrierA", "country": "US", "premium": 100, "value": 1000, "expectedVersion": 1}`))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("assignTerms", policy.ID, "carrierB", "DE", "200", "2000", "2"))
+
+	// Both carriers vote having read version 3; the second must re-read
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve", "", "3"))
+	_, err := l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve", "", "3")
+	if err == nil || asChaincodeError(err).Code != codeVersionConflict {
+		t.Fatalf("got error %v, want %s", err, codeVersionConflict)
+	}
+	if details := asChaincodeError(err).Details; details["version"] != 4 || details["expectedVersion"] != 3 {
+		t.Fatalf("unexpected conflict details: %+v", details)
+	}
+
+	mustSucceed(t)(l.invoke("castVote", policy.ID, "carrierB", "approve", "", "4"))
+	if active := onlyPolicy(t, l, "getActivePolicies"); active.Version != 5 {
+		t.Fatalf("active policy at version %d, want 5", active.Version)
+	}
+}
+
+func TestExpectedVersionOnOtherChanges(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	conflict := func(result []byte, err error) {
+		t.Helper()
+		if err == nil || asChaincodeError(err).Code != codeVersionConflict {
+			t.Fatalf("got error %v, want %s", err, codeVersionConflict)
+		}
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("modifyPolicy", policy.ID, "carrierA", "US", "150", "1000", "5"))
+	conflict(l.as(holderRole, "acme").invoke("consentToModification", policy.ID, "acme", "approve", "", "5"))
+	mustSucceed(t)(l.invoke("consentToModification", `{"policyID": "` + policy.ID + `", "holderID": "acme", "vote": "approve", "expectedVersion": 6}`))
+	conflict(l.as(carrierRole, "carrierA").invoke("withdrawModification", policy.ID, "6"))
+	mustSucceed(t)(l.invoke("withdrawModification", policy.ID, "7"))
+	conflict(l.as(holderRole, "acme").invoke("cancelPolicy", policy.ID, "holderRequest", "2017-06-01", "US", "7"))
+	mustSucceed(t)(l.invoke("cancelPolicy", policy.ID, "holderRequest", "2017-06-01", "US", "8"))
+	conflict(l.invoke("endorsePolicy", policy.ID, "addCountry", "FR", "8"))
+	mustSucceed(t)(l.invoke("endorsePolicy", `{"policyID": "` + policy.ID + `", "action": "addCountry", "country": "FR", "expectedVersion": 9}`))
+
+	successorID := string(mustSucceed(t)(l.invoke("renewPolicy", policy.ID)))
+	conflict(l.as(carrierRole, "carrierB").invoke("confirmTerms", successorID, "carrierB", "2"))
+	mustSucceed(t)(l.invoke("confirmTerms", `{"policyID": "` + successorID + `", "carrier": "carrierB", "expectedVersion": 1}`))
+}
+
+func TestPolicyExpiry(t *testing.T) {
+	l := newTestLedger(t)
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("generatePolicy", `{"holderID": "acme", "countries": ["US", "DE"], "effectiveDate": "2017-01-01", "expiryDate": "2017-03-01"}`))
+	policy := onlyPolicy(t, l, "getIncompletePolicies")
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "US", "100", "1000"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("assignTerms", `{"policyID": "` + policy.ID + `", "carrier": "carrierB", "country": "DE", "premium": 200, "value": 2000, "expiryDate": "2017-02-01"}`))
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+
+	active := onlyPolicy(t, l, "getActivePolicies")
+	if active.Terms[0].ExpiryDate != "2017-03-01" || active.Terms[1].ExpiryDate != "2017-02-01" {
+		t.Fatalf("unexpected terms periods: %+v", active.Terms)
+	}
+
+	expiring := func(days string) []Policy {
+		t.Helper()
+		var policies AllPolicies
+		err := json.Unmarshal(mustSucceed(t)(l.as(carrierRole, "carrierA").query("getExpiringPolicies", days)), &policies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return policies.Catalog
+	}
+
+	if len(expiring("30")) != 0 {
+		t.Fatal("policy expiring in two months listed as expiring within 30 days")
+	}
+	l.txTime = time.Date(2017, 2, 15, 0, 0, 0, 0, time.UTC).Unix()
+	if len(expiring("30")) != 1 {
+		t.Fatal("policy expiring in two weeks not listed as expiring within 30 days")
+	}
+
+	if string(mustSucceed(t)(l.as(adminRole, "admin").invoke("expirePolicies"))) != "[]" {
+		t.Fatal("policy expired before its expiry date")
+	}
+	l.txTime = time.Date(2017, 3, 2, 0, 0, 0, 0, time.UTC).Unix()
+	if string(mustSucceed(t)(l.invoke("expirePolicies"))) != `["` + policy.ID + `"]` {
+		t.Fatal("lapsed policy not expired")
+	}
+	if len(readStage(t, l, "getActivePolicies")) != 0 {
+		t.Fatal("expired policy left in active policies")
+	}
+	var page PolicyPage
+	err := json.Unmarshal(mustSucceed(t)(l.query("listPolicies", `{"stage": "expired"}`)), &page)
+	if err != nil || len(page.Policies) != 1 || page.Policies[0].Status != expiredStage {
+		t.Fatalf("expired listing returned %+v, %v", page, err)
+	}
+}
+
+func TestClaimLifecycle(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	readClaim := func(claimID string) Claim {
+		t.Helper()
+		var claim Claim
+		err := json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getClaim", claimID)), &claim)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claim
+	}
+	wantCode := func(code string) func([]byte, error) {
+		return func(result []byte, err error) {
+			t.Helper()
+			if err == nil || asChaincodeError(err).Code != code {
+				t.Fatalf("got error %v, want %s", err, code)
+			}
+		}
+	}
+
+	claimID := string(mustSucceed(t)(l.as(holderRole, "acme").invoke("fileClaim", policy.ID, "US", "600", "2017-01-01", "doc1")))
+	if claim := readClaim(claimID); claim.Status != claimFiled || claim.CarrierID != "carrierA" || claim.TermsID != policy.Terms[0].ID || len(claim.Documents) != 1 {
+		t.Fatalf("unexpected filed claim: %+v", claim)
+	}
+
+	wantCode(codeUnauthorized)(l.as(carrierRole, "carrierB").invoke("adjudicateClaim", claimID, "carrierB", "accept", "0", ""))
+	wantCode(codeWrongStage)(l.as(carrierRole, "carrierA").invoke("recordPayout", claimID, "carrierA", "100", "wire-1"))
+	wantCode(codeInvalidArgument)(l.invoke("adjudicateClaim", claimID, "carrierA", "partial", "600", "excess"))
+	mustSucceed(t)(l.invoke("adjudicateClaim", claimID, "carrierA", "partial", "400", "excess"))
+	wantCode(codeWrongStage)(l.invoke("adjudicateClaim", claimID, "carrierA", "accept", "0", ""))
+
+	wantCode(codeInvalidArgument)(l.invoke("recordPayout", claimID, "carrierA", "500", "wire-1"))
+	mustSucceed(t)(l.invoke("recordPayout", claimID, "carrierA", "300", "wire-1"))
+	if claim := readClaim(claimID); claim.Status != claimPartial || len(claim.Payouts) != 1 {
+		t.Fatalf("unexpected claim after first payout: %+v", claim)
+	}
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("recordPayout", claimID, "carrierA", "100", "wire-2"))
+	if claim := readClaim(claimID); claim.Status != claimPaid || len(claim.Payouts) != 2 {
+		t.Fatalf("unexpected claim after final payout: %+v", claim)
+	}
+	wantCode(codeWrongStage)(l.as(carrierRole, "carrierA").invoke("recordPayout", claimID, "carrierA", "1", "wire-3"))
+
+	// The US terms are worth 1000, of which 400 has been approved
+	second := string(mustSucceed(t)(l.as(holderRole, "acme").invoke("fileClaim", policy.ID, "US", "700", "2017-01-01")))
+	wantCode(codeInvalidArgument)(l.as(carrierRole, "carrierA").invoke("adjudicateClaim", second, "carrierA", "accept", "0", ""))
+	wantCode(codeInvalidArgument)(l.invoke("adjudicateClaim", second, "carrierA", "deny", "0", ""))
+	mustSucceed(t)(l.invoke("adjudicateClaim", second, "carrierA", "deny", "0", "not covered"))
+	third := string(mustSucceed(t)(l.as(holderRole, "acme").invoke("fileClaim", policy.ID, "US", "600", "2017-01-01")))
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("adjudicateClaim", third, "carrierA", "accept", "0", ""))
+
+	var claims AllClaims
+	err := json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getClaimsByPolicy", policy.ID)), &claims)
+	if err != nil || len(claims.Catalog) != 3 {
+		t.Fatalf("getClaimsByPolicy returned %+v, %v", claims, err)
+	}
+	if claim := readClaim(second); claim.Status != claimDenied || claim.Adjudication.Reason != "not covered" {
+		t.Fatalf("unexpected denied claim: %+v", claim)
+	}
+}
+
+func TestClaimsOnExpiredPolicy(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	claimID := string(mustSucceed(t)(l.as(holderRole, "acme").invoke("fileClaim", policy.ID, "US", "300", "2017-01-01")))
+	l.txTime = time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC).Unix()
+	mustSucceed(t)(l.as(adminRole, "admin").invoke("expirePolicies"))
+	if len(readStage(t, l, "getActivePolicies")) != 0 {
+		t.Fatal("lapsed policy left in active policies")
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("adjudicateClaim", claimID, "carrierA", "accept", "0", ""))
+	var claims AllClaims
+	err := json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getClaimsByPolicy", policy.ID)), &claims)
+	if err != nil || len(claims.Catalog) != 1 || claims.Catalog[0].Status != claimAccepted {
+		t.Fatalf("claims on expired policy returned %+v, %v", claims, err)
+	}
+}
+
+func TestClaimsAfterModification(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("modifyPolicy", `{"policyID": "` + policy.ID + `", "carrier": "carrierA", "country": "US", "premium": 150, "value": 3000, "effectiveDate": "2017-07-01"}`))
+	mustSucceed(t)(l.invoke("castVote", policy.ID, "carrierA", "approve"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("consentToModification", policy.ID, "acme", "approve"))
+
+	l.txTime = time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC).Unix()
+	before := string(mustSucceed(t)(l.invoke("fileClaim", policy.ID, "US", "300", "2017-03-01")))
+	after := string(mustSucceed(t)(l.invoke("fileClaim", policy.ID, "US", "900", "2017-07-15")))
+	again := string(mustSucceed(t)(l.invoke("fileClaim", policy.ID, "US", "800", "2017-04-01")))
+
+	var claim Claim
+	err := json.Unmarshal(mustSucceed(t)(l.query("getClaim", before)), &claim)
+	if err != nil || claim.CarrierID != "carrierA" || claim.TermsID != policy.Terms[0].ID {
+		t.Fatalf("claim before the modification took effect resolved to %+v, %v", claim, err)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("adjudicateClaim", before, "carrierA", "accept", "0", ""))
+	mustSucceed(t)(l.invoke("adjudicateClaim", after, "carrierA", "accept", "0", ""))
+	_, err = l.invoke("adjudicateClaim", again, "carrierA", "accept", "0", "")
+	if err == nil || asChaincodeError(err).Code != codeInvalidArgument {
+		t.Fatalf("got error %v, want %s", err, codeInvalidArgument)
+	}
+}
+
+func TestPolicyRenewal(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	successorID := string(mustSucceed(t)(l.as(holderRole, "acme").invoke("renewPolicy", policy.ID)))
+	renewal := onlyPolicy(t, l, "getIncompletePolicies")
+	if renewal.ID != successorID || renewal.PredecessorID != policy.ID {
+		t.Fatalf("unexpected renewal: %+v", renewal)
+	}
+	if renewal.EffectiveDate != "2018-01-02" || renewal.ExpiryDate != "2019-01-02" {
+		t.Fatalf("renewal period %s to %s, want 2018-01-02 to 2019-01-02", renewal.EffectiveDate, renewal.ExpiryDate)
+	}
+	if renewal.Terms[0].CarrierID != "carrierA" || renewal.Terms[0].Premium != 100 || renewal.Terms[0].ID != "" {
+		t.Fatalf("predecessor terms not proposed: %+v", renewal.Terms[0])
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("confirmTerms", renewal.ID, "carrierA"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("assignTerms", renewal.ID, "carrierB", "DE", "250", "2000"))
+	pending := onlyPolicy(t, l, "getPendingPolicies")
+	if pending.Terms[0].ID == "" || pending.Terms[0].ID == policy.Terms[0].ID || pending.Terms[0].Premium != 100 || pending.Terms[0].ExpiryDate != "2019-01-02" || pending.Terms[1].Premium != 250 {
+		t.Fatalf("unexpected renewal terms: %+v", pending.Terms)
+	}
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", renewal.ID, "carrierA", "approve"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", renewal.ID, "carrierB", "approve"))
+
+	var chain AllPolicies
+	err := json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getPolicyChain", policy.ID)), &chain)
+	if err != nil || len(chain.Catalog) != 2 || chain.Catalog[0].ID != policy.ID || chain.Catalog[1].ID != renewal.ID || chain.Catalog[1].Status != activeStage {
+		t.Fatalf("unexpected policy chain: %+v, %v", chain, err)
+	}
+
+	_, err = l.invoke("renewPolicy", policy.ID)
+	if err == nil || asChaincodeError(err).Code != codeAlreadyExists {
+		t.Fatalf("got error %v, want %s", err, codeAlreadyExists)
+	}
+}
+
+func TestPolicyCancellation(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("cancelPolicy", policy.ID, "carrierWithdrawal", "2017-07-02", "US"))
+	if events := lastEvents(t, l); len(events) != 1 || events[0].Type != termsCancelledEvent {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	active := onlyPolicy(t, l, "getActivePolicies")
+	if active.Terms[0].CancellationDate != "2017-07-02" || active.Terms[1].CancellationDate != "" || len(active.Cancellations) != 1 {
+		t.Fatalf("unexpected terms after cancelling US: %+v", active)
+	}
+	refund := active.Cancellations[0].Refunds[0]
+	if refund.CarrierID != "carrierA" || refund.TotalDays != 366 || refund.UnusedDays != 184 || refund.Amount != 50 {
+		t.Fatalf("unexpected refund: %+v", refund)
+	}
+
+	_, err := l.invoke("cancelPolicy", policy.ID, "other", "2017-07-02", "US")
+	if err == nil || asChaincodeError(err).Code != codeConflictingTerms {
+		t.Fatalf("got error %v, want %s", err, codeConflictingTerms)
+	}
+
+	var history HistoryPage
+	err = json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getPolicyHistory", policy.ID)), &history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := history.Entries[len(history.Entries) - 1]
+	if len(entry.TermsDiff) != 1 || entry.TermsDiff[0].Previous.CancellationDate != "" || entry.TermsDiff[0].Current.CancellationDate != "2017-07-02" {
+		t.Fatalf("cancelled terms missing from history: %+v", entry)
+	}
+
+	// Cancelling the remaining terms keeps the policy active until the
+	// latest cancellation date, when expirePolicies moves it to cancelled
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("cancelPolicy", policy.ID, "holderRequest", "2017-03-01"))
+	active = onlyPolicy(t, l, "getActivePolicies")
+	if active.Terms[0].CancellationDate != "2017-07-02" || active.Terms[1].CancellationDate != "2017-03-01" {
+		t.Fatalf("unexpected terms after cancelling the policy: %+v", active)
+	}
+	l.txTime = time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+	if string(mustSucceed(t)(l.as(adminRole, "admin").invoke("expirePolicies"))) != "[]" {
+		t.Fatal("policy cancelled before its last terms")
+	}
+	l.txTime = time.Date(2017, 7, 2, 0, 0, 0, 0, time.UTC).Unix()
+	if string(mustSucceed(t)(l.invoke("expirePolicies"))) != `["` + policy.ID + `"]` {
+		t.Fatal("policy not cancelled on the date of its last terms")
+	}
+	if events := lastEvents(t, l); len(events) != 1 || events[0].Type != policyCancelledEvent {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	var page PolicyPage
+	err = json.Unmarshal(mustSucceed(t)(l.as(adminRole, "admin").query("listPolicies", `{"stage": "cancelled"}`)), &page)
+	if err != nil || len(page.Policies) != 1 {
+		t.Fatalf("cancelled listing returned %+v, %v", page, err)
+	}
+	cancelled := page.Policies[0]
+	if cancelled.Status != cancelledStage || len(cancelled.Cancellations) != 2 || cancelled.Terms[0].CancellationDate != "2017-07-02" {
+		t.Fatalf("unexpected cancelled policy: %+v", cancelled)
+	}
+	if refunds := cancelled.Cancellations[1].Refunds; len(refunds) != 1 || refunds[0].CarrierID != "carrierB" || refunds[0].Amount != 167 {
+		t.Fatalf("unexpected refunds: %+v", refunds)
+	}
+	if len(readStage(t, l, "getActivePolicies")) != 0 {
+		t.Fatal("cancelled policy still active")
+	}
+}
+
+func TestPolicyEndorsement(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("endorsePolicy", policy.ID, "addCountry", "FR"))
+	if active := onlyPolicy(t, l, "getActivePolicies"); len(active.Countries) != 2 || active.Endorsement != nil {
+		t.Fatalf("active policy changed by pending endorsement: %+v", active)
+	}
+	endorsed := onlyPolicy(t, l, "getIncompletePolicies")
+	if endorsed.ID != policy.ID || endorsed.Endorsement == nil || endorsed.Terms[2].Country != "FR" || endorsed.Votes[0].Vote != "approve" {
+		t.Fatalf("unexpected endorsed policy: %+v", endorsed)
+	}
+
+	// carrierA's approval of its US terms stands; only its new FR terms are voted on
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "FR", "50", "500"))
+	mustSucceed(t)(l.invoke("castVote", policy.ID, "carrierA", "approve"))
+	active := onlyPolicy(t, l, "getActivePolicies")
+	if strings.Join(active.Countries, ",") != "US,DE,FR" || active.Endorsement != nil || active.Terms[2].Premium != 50 {
+		t.Fatalf("add country endorsement not applied: %+v", active)
+	}
+	if len(readStage(t, l, "getPendingPolicies")) != 0 {
+		t.Fatal("endorsed policy left pending")
+	}
+
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("endorsePolicy", policy.ID, "removeCountry", "DE"))
+	_, err := l.invoke("endorsePolicy", policy.ID, "removeCountry", "FR")
+	if err == nil || asChaincodeError(err).Code != codeWrongStage {
+		t.Fatalf("got error %v, want %s", err, codeWrongStage)
+	}
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "disapprove", "still insured"))
+	active = onlyPolicy(t, l, "getActivePolicies")
+	if len(active.Countries) != 3 || active.Endorsement != nil || len(active.Rejections) != 1 {
+		t.Fatalf("rejected removal changed the policy: %+v", active)
+	}
+
+	l.txTime = time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("endorsePolicy", policy.ID, "removeCountry", "DE"))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+	active = onlyPolicy(t, l, "getActivePolicies")
+	if strings.Join(active.Countries, ",") != "US,FR" || len(active.Terms) != 2 || len(active.Votes) != 2 {
+		t.Fatalf("remove country endorsement not applied: %+v", active)
+	}
+	if len(active.RemovedTerms) != 1 || active.RemovedTerms[0].Country != "DE" || active.RemovedTerms[0].CancellationDate != "2017-03-01" {
+		t.Fatalf("removed terms not kept: %+v", active.RemovedTerms)
+	}
+
+	// The removed country covers incidents before its removal only
+	l.txTime = time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC).Unix()
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("fileClaim", policy.ID, "DE", "100", "2017-02-01"))
+	_, err = l.invoke("fileClaim", policy.ID, "DE", "100", "2017-04-01")
+	if err == nil || asChaincodeError(err).Code != codeInvalidArgument {
+		t.Fatalf("got error %v, want %s", err, codeInvalidArgument)
+	}
+}
+
+func TestExpiryWithdrawsEndorsement(t *testing.T) {
+	l := newTestLedger(t)
+	policy := activePolicy(t, l)
+
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("endorsePolicy", policy.ID, "addCountry", "FR"))
+	l.txTime = time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC).Unix()
+	mustSucceed(t)(l.as(adminRole, "admin").invoke("expirePolicies"))
+	if len(readStage(t, l, "getIncompletePolicies")) != 0 {
+		t.Fatal("endorsement of expired policy left open")
+	}
+	var quotes AllPolicies
+	err := json.Unmarshal(mustSucceed(t)(l.as(carrierRole, "carrierA").query("getOpenQuoteRequests", "carrierA")), &quotes)
+	if err != nil || len(quotes.Catalog) != 0 {
+		t.Fatalf("open quote requests returned %+v, %v", quotes, err)
+	}
+
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("renewPolicy", policy.ID))
+	if renewal := onlyPolicy(t, l, "getIncompletePolicies"); renewal.PredecessorID != policy.ID {
+		t.Fatalf("unexpected renewal: %+v", renewal)
+	}
+}
+
+func TestGetStateMachine(t *testing.T) {
+	l := newTestLedger(t)
+	dot := string(mustSucceed(t)(l.as(adminRole, "admin").query("getStateMachine")))
+	if !strings.HasPrefix(dot, "digraph policy {") {
+		t.Fatalf("not a DOT graph: %s", dot)
+	}
+	for _, edge := range []string{`"incomplete" -> "pending"`, `"pending" -> "active"`, `"pending" -> "incomplete"`, `"active" -> "pending"`} {
+		if !strings.Contains(dot, edge) {
+			t.Fatalf("state machine missing %s: %s", edge, dot)
+		}
+	}
+}
+
+func TestGetPolicy(t *testing.T) {
+	l := newTestLedger(t)
+	policy := incompletePolicy(t, l)
+
+	getPolicy := func() PolicyDetail {
+		t.Helper()
+		var detail PolicyDetail
+		err := json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getPolicy", policy.ID)), &detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return detail
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "US", "100", "1000"))
+	detail := getPolicy()
+	if detail.Stage != incompleteStage || strings.Join(detail.OutstandingCountries, ",") != "DE" || len(detail.OutstandingVoters) != 0 {
+		t.Fatalf("unexpected incomplete policy detail: %+v", detail)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("assignTerms", policy.ID, "carrierB", "DE", "200", "2000"))
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve"))
+	detail = getPolicy()
+	if detail.Stage != pendingStage || len(detail.OutstandingCountries) != 0 || strings.Join(detail.OutstandingVoters, ",") != "carrierB" {
+		t.Fatalf("unexpected pending policy detail: %+v", detail)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+	detail = getPolicy()
+	if detail.Stage != activeStage || detail.Policy.ID != policy.ID || len(detail.OutstandingVoters) != 0 {
+		t.Fatalf("unexpected active policy detail: %+v", detail)
+	}
+}
+
+func TestListPolicies(t *testing.T) {
+	l := newTestLedger(t)
+	incompletePolicy(t, l)
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("generatePolicy", "acme", "US"))
+	mustSucceed(t)(l.invoke("generatePolicy", "acme", "FR"))
+
+	list := func(request string) PolicyPage {
+		t.Helper()
+		var page PolicyPage
+		err := json.Unmarshal(mustSucceed(t)(l.as(carrierRole, "carrierA").query("listPolicies", request)), &page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+
+	first := list(`{"stage": "incomplete", "pageSize": 2}`)
+	if len(first.Policies) != 2 || first.Total != nil || first.Bookmark != first.Policies[1].ID {
+		t.Fatalf("unexpected first page: %+v", first)
+	}
+	second := list(`{"stage": "incomplete", "pageSize": 2, "bookmark": "` + first.Bookmark + `"}`)
+	if len(second.Policies) != 1 || second.Bookmark != "" || second.Policies[0].ID <= first.Bookmark {
+		t.Fatalf("unexpected second page: %+v", second)
+	}
+	counted := list(`{"stage": "incomplete", "pageSize": 2, "bookmark": "` + first.Bookmark + `", "includeTotal": true}`)
+	if counted.Total == nil || *counted.Total != 3 || len(counted.Policies) != 1 || counted.Policies[0].ID != second.Policies[0].ID {
+		t.Fatalf("unexpected counted page: %+v", counted)
+	}
+
+	if page := list(`{"stage": "incomplete", "country": "US"}`); len(page.Policies) != 2 {
+		t.Fatalf("country filter returned %+v", page)
+	}
+
+	quoted := list(`{"stage": "incomplete", "country": "DE"}`).Policies[0]
+	mustSucceed(t)(l.invoke("assignTerms", quoted.ID, "carrierA", "US", "100", "1000"))
+	if page := list(`{"stage": "incomplete", "carrier": "carrierA", "minPremium": 50, "maxValue": 1000}`); len(page.Policies) != 1 || page.Policies[0].ID != quoted.ID {
+		t.Fatalf("carrier and range filters returned %+v", page)
+	}
+	if page := list(`{"stage": "incomplete", "minPremium": 101, "includeTotal": true}`); page.Total == nil || *page.Total != 0 || len(page.Policies) != 0 {
+		t.Fatalf("premium filter returned %+v", page)
+	}
+
+	var page PolicyPage
+	err := json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("listPolicies", `{"stage": "incomplete", "holderID": "acme"}`)), &page)
+	if err != nil || len(page.Policies) != 3 {
+		t.Fatalf("holder listing returned %+v, %v", page, err)
+	}
+}
+
+func TestCarrierWorkQueues(t *testing.T) {
+	l := newTestLedger(t)
+	policy := incompletePolicy(t, l)
+
+	workQueue := func(function string, carrierID string) []Policy {
+		t.Helper()
+		var policies AllPolicies
+		err := json.Unmarshal(mustSucceed(t)(l.as(carrierRole, carrierID).query(function, carrierID)), &policies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return policies.Catalog
+	}
+
+	if len(workQueue("getOpenQuoteRequests", "carrierA")) != 1 || len(workQueue("getOpenQuoteRequests", "carrierB")) != 1 {
+		t.Fatal("new policy missing from open quote requests")
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "US", "100", "1000"))
+	if len(workQueue("getOpenQuoteRequests", "carrierA")) != 0 {
+		t.Fatal("quoted country still listed as an open quote request")
+	}
+	if len(workQueue("getOpenQuoteRequests", "carrierB")) != 1 {
+		t.Fatal("unquoted country missing from open quote requests")
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("assignTerms", policy.ID, "carrierB", "DE", "200", "2000"))
+	if len(workQueue("getOpenQuoteRequests", "carrierB")) != 0 || len(workQueue("getPendingVotes", "carrierA")) != 1 || len(workQueue("getPendingVotes", "carrierB")) != 1 {
+		t.Fatal("complete policy not moved from open quote requests to pending votes")
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve"))
+	if len(workQueue("getPendingVotes", "carrierA")) != 0 || len(workQueue("getPendingVotes", "carrierB")) != 1 {
+		t.Fatal("pending votes not updated after a vote")
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", policy.ID, "carrierB", "approve"))
+	if len(workQueue("getPendingVotes", "carrierB")) != 0 {
+		t.Fatal("activated policy still listed as a pending vote")
+	}
+}
+
+func TestCarrierStatus(t *testing.T) {
+	l := newTestLedger(t)
+	policy := incompletePolicy(t, l)
+
+	byCountry := func(country string) []Carrier {
+		t.Helper()
+		var carriers AllCarriers
+		err := json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getCarriersByCountry", country)), &carriers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return carriers.Catalog
+	}
+
+	if carriers := byCountry("FR"); len(carriers) != 1 || carriers[0].ID != "carrierA" || carriers[0].Status != carrierActive {
+		t.Fatalf("getCarriersByCountry(FR) returned %+v", carriers)
+	}
+	if carriers := byCountry("DE"); len(carriers) != 1 || carriers[0].ID != "carrierB" {
+		t.Fatalf("getCarriersByCountry(DE) returned %+v", carriers)
+	}
+	if carriers := byCountry("JP"); len(carriers) != 0 {
+		t.Fatalf("getCarriersByCountry(JP) returned %+v", carriers)
+	}
+
+	mustSucceed(t)(l.as(adminRole, "admin").invoke("setCarrierStatus", "carrierA", carrierSuspended))
+	if carriers := byCountry("US"); len(carriers) != 1 || carriers[0].Status != carrierSuspended {
+		t.Fatalf("suspension not recorded: %+v", carriers)
+	}
+	_, err := l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "US", "100", "1000")
+	if err == nil || asChaincodeError(err).Code != codeCarrierNotEligible {
+		t.Fatalf("got error %v, want %s", err, codeCarrierNotEligible)
+	}
+
+	mustSucceed(t)(l.as(adminRole, "admin").invoke("setCarrierStatus", "carrierA", carrierActive))
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "US", "100", "1000"))
+}
+
+func TestDuplicateVote(t *testing.T) {
+	l := newTestLedger(t)
+	policy := pendingPolicy(t, l)
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", policy.ID, "carrierA", "approve"))
+	_, err := l.invoke("castVote", policy.ID, "carrierA", "approve")
+	if err == nil || asChaincodeError(err).Code != codeDuplicateVote {
+		t.Fatalf("got error %v, want %s", err, codeDuplicateVote)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	response := toResponse(nil, newError(codePolicyNotFound, "No policy found with hash: p").with("policyID", "p"))
+
+	var chaincodeError ChaincodeError
+	err := json.Unmarshal([]byte(response.Message), &chaincodeError)
+	if err != nil {
+		t.Fatalf("error response is not JSON: %s", response.Message)
+	}
+	if chaincodeError.Code != codePolicyNotFound || chaincodeError.Details["policyID"] != "p" {
+		t.Fatalf("unexpected error response: %s", response.Message)
+	}
+
+	response = toResponse(nil, errors.New("ledger unavailable"))
+	if !strings.Contains(response.Message, codeInternal) {
+		t.Fatalf("uncoded error not reported as %s: %s", codeInternal, response.Message)
+	}
+}
+
+func TestMakeHashSeparatesArgs(t *testing.T) {
+	if makeHash("tx", []string{"ab", "c"}) == makeHash("tx", []string{"a", "bc"}) {
+		t.Fatal("ambiguous concatenations share a hash")
+	}
+	if makeHash("tx1", []string{"acme", "US"}) == makeHash("tx2", []string{"acme", "US"}) {
+		t.Fatal("identical args in separate transactions share a hash")
+	}
+}
+
+func TestInitMigratesLegacyCatalogs(t *testing.T) {
+	l := newMemoryLedger()
+	legacy := AllPolicies{Catalog: []Policy{{ID: "legacy", HolderID: "acme", Countries: []string{"US"}}}}
+	legacyAsBytes, _ := json.Marshal(legacy)
+	l.state[activePoliciesString] = legacyAsBytes
+	quote := AllPolicies{Catalog: []Policy{{ID: "quote", HolderID: "acme", Countries: []string{"FR"}, Terms: []CarrierTerms{{Country: "FR"}}}}}
+	quoteAsBytes, _ := json.Marshal(quote)
+	l.state[incompletePoliciesString] = quoteAsBytes
+
+	// Every migration runs in the one transaction, each seeing the writes of
+	// the steps before it
+	mustSucceed(t)(l.as(adminRole, "admin").init())
+	mustSucceed(t)(l.init())
+
+	if l.state[activePoliciesString] != nil {
+		t.Fatal("legacy catalog was not removed")
+	}
+	if active := onlyPolicy(t, l, "getActivePolicies"); active.ID != "legacy" || active.Status != activeStage || active.Version != 1 {
+		t.Fatalf("legacy policy was not migrated: %+v", active)
+	}
+	var first Policy
+	err := json.Unmarshal(mustSucceed(t)(l.query("getPolicyVersion", "legacy", "1")), &first)
+	if err != nil || first.Status != activeStage {
+		t.Fatalf("unexpected first version of legacy policy: %+v, %v", first, err)
+	}
+	mustSucceed(t)(l.invoke("registerCarrier", "carrierA", "Carrier A", "FR"))
+	var queue AllPolicies
+	err = json.Unmarshal(mustSucceed(t)(l.as(carrierRole, "carrierA").query("getOpenQuoteRequests", "carrierA")), &queue)
+	if err != nil || len(queue.Catalog) != 1 || queue.Catalog[0].ID != "quote" {
+		t.Fatalf("open quote not indexed for migrated policy: %+v, %v", queue, err)
+	}
+	mustSucceed(t)(l.as(adminRole, "admin").invoke("registerHolder", "acme", "Acme Corp", "US", ""))
+	var holderPolicies AllPolicies
+	err = json.Unmarshal(mustSucceed(t)(l.as(holderRole, "acme").query("getPoliciesByHolder", "acme")), &holderPolicies)
+	if err != nil || len(holderPolicies.Catalog) != 2 {
+		t.Fatalf("migrated policies not indexed for their holder: %+v, %v", holderPolicies, err)
+	}
+	if string(l.state[schemaVersionString]) != strconv.Itoa(currentSchemaVersion()) {
+		t.Fatalf("schema version %q, want %d", l.state[schemaVersionString], currentSchemaVersion())
+	}
+}
+
+func TestInitMigratesDuplicateLegacyIDs(t *testing.T) {
+	l := newMemoryLedger()
+	legacy := AllPolicies{Catalog: []Policy{{ID: "dup", HolderID: "acme", Countries: []string{"US"}}, {ID: "dup", HolderID: "other", Countries: []string{"DE"}}}}
+	legacyAsBytes, _ := json.Marshal(legacy)
+	l.state[activePoliciesString] = legacyAsBytes
+
+	mustSucceed(t)(l.as(adminRole, "admin").init())
+	active := readStage(t, l, "getActivePolicies")
+	if len(active) != 2 || active[0].ID == active[1].ID {
+		t.Fatalf("duplicate legacy policies not both migrated: %+v", active)
+	}
+	holders := map[string]string{}
+	for _, policy := range active {
+		holders[policy.HolderID] = policy.ID
+	}
+	if holders["acme"] != "dup" || holders["other"] == "" {
+		t.Fatalf("unexpected migrated policies: %+v", active)
+	}
+}
+
+func TestInitUpgradesFromSchemaVersion2(t *testing.T) {
+	l := newTestLedger(t)
+	policy := incompletePolicy(t, l)
+
+	// Put the ledger back as schema version 2 left it: no status, no versions
+	stored := policy
+	stored.Status = ""
+	stored.Version = 0
+	storedAsBytes, _ := json.Marshal(stored)
+	l.state[policyKey(incompleteStage, policy.ID)] = storedAsBytes
+	for key := range l.state {
+		if strings.HasPrefix(key, policyVersionKeyPrefix) || strings.HasPrefix(key, policyVersionHeadKeyPrefix) {
+			delete(l.state, key)
+		}
+	}
+	l.state[schemaVersionString] = []byte("2")
+
+	mustSucceed(t)(l.as(adminRole, "admin").init())
+	upgraded := onlyPolicy(t, l, "getIncompletePolicies")
+	if upgraded.Status != incompleteStage || upgraded.Version != 1 {
+		t.Fatalf("unexpected upgraded policy: %+v", upgraded)
+	}
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", policy.ID, "carrierA", "US", "100", "1000"))
+	if quoted := onlyPolicy(t, l, "getIncompletePolicies"); quoted.Version != 2 {
+		t.Fatalf("quoted policy at version %d, want 2", quoted.Version)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		stage string
+		role string
+		orgID string
+		function string
+		args []string
+		code string
+		want string
+	}{
+		{"init by carrier", "", carrierRole, "carrierA", "init", nil, codeUnauthorized, "may not call init"},
+		{"unknown function", "", adminRole, "admin", "burnPolicies", nil, codeUnknownFunction, "No access rule"},
+		{"unknown role", "", "broker", "b1", "getCarriers", nil, codeUnauthorized, "unknown role"},
+		{"duplicate holder", "", adminRole, "admin", "registerHolder", []string{"acme", "Acme", "US", ""}, codeAlreadyExists, "already registered"},
+		{"holder missing fields", "", adminRole, "admin", "registerHolder", []string{"h2", "", "US", ""}, codeInvalidArgument, "are required"},
+		{"duplicate carrier", "", adminRole, "admin", "registerCarrier", []string{"carrierA", "A", "US"}, codeAlreadyExists, "already registered"},
+		{"holder ID with separator", "", adminRole, "admin", "registerHolder", []string{"acme~evil", "Evil", "US", ""}, codeInvalidArgument, "holderID must not contain"},
+		{"carrier ID with separator", "", adminRole, "admin", "registerCarrier", []string{"carrierA~x", "X", "US"}, codeInvalidArgument, "carrier must not contain"},
+		{"licensed country with separator", "", adminRole, "admin", "registerCarrier", []string{"carrierC", "C", "US~x"}, codeInvalidArgument, "licensedCountries must not contain"},
+		{"generate country with separator", "", holderRole, "acme", "generatePolicy", []string{"acme", "US~x"}, codeInvalidArgument, "countries[0]: must not contain"},
+		{"endorse country with separator", "active", holderRole, "acme", "endorsePolicy", []string{"ID", "addCountry", "FR~x"}, codeInvalidArgument, "country: must not contain"},
+		{"invalid carrier status", "", adminRole, "admin", "setCarrierStatus", []string{"carrierA", "closed"}, codeInvalidArgument, "Invalid carrier status"},
+		{"generate with one arg", "", holderRole, "acme", "generatePolicy", []string{"acme"}, codeInvalidArgument, "Expected multiple arguments"},
+		{"generate for another holder", "", holderRole, "acme", "generatePolicy", []string{"other", "US"}, codeUnauthorized, "may not act as holder"},
+		{"generate for unregistered holder", "", holderRole, "ghost", "generatePolicy", []string{"ghost", "US"}, codeNotFound, "No holder registered"},
+		{"generate by carrier", "", carrierRole, "carrierA", "generatePolicy", []string{"acme", "US"}, codeUnauthorized, "may not call generatePolicy"},
+		{"assign with 4 args", "incomplete", carrierRole, "carrierA", "assignTerms", []string{"ID", "carrierA", "US", "100"}, codeInvalidArgument, "Expected 5 or 6 arguments"},
+		{"assign to unknown policy", "incomplete", carrierRole, "carrierA", "assignTerms", []string{"nope", "carrierA", "US", "100", "1000"}, codePolicyNotFound, "No policy found"},
+		{"assign bad value", "incomplete", carrierRole, "carrierA", "assignTerms", []string{"ID", "carrierA", "US", "100", "lots"}, codeInvalidArgument, "value: must be an integer"},
+		{"assign as another carrier", "incomplete", carrierRole, "carrierA", "assignTerms", []string{"ID", "carrierB", "DE", "100", "1000"}, codeUnauthorized, "may not act as carrier"},
+		{"assign unregistered carrier", "incomplete", carrierRole, "carrierZ", "assignTerms", []string{"ID", "carrierZ", "US", "100", "1000"}, codeNotFound, "No carrier registered"},
+		{"assign unlicensed country", "incomplete", carrierRole, "carrierB", "assignTerms", []string{"ID", "carrierB", "US", "100", "1000"}, codeCarrierNotEligible, "not licensed"},
+		{"assign country not on policy", "incomplete", carrierRole, "carrierA", "assignTerms", []string{"ID", "carrierA", "FR", "100", "1000"}, codeInvalidArgument, "does not require country"},
+		{"vote with 2 args", "pending", carrierRole, "carrierA", "castVote", []string{"ID", "carrierA"}, codeInvalidArgument, "Expected three to five arguments"},
+		{"invalid vote", "pending", carrierRole, "carrierA", "castVote", []string{"ID", "carrierA", "abstain"}, codeInvalidArgument, "vote: must be"},
+		{"disapprove without reason", "pending", carrierRole, "carrierA", "castVote", []string{"ID", "carrierA", "disapprove"}, codeInvalidArgument, "reason: is required"},
+		{"vote as another carrier", "pending", carrierRole, "carrierA", "castVote", []string{"ID", "carrierB", "approve"}, codeUnauthorized, "may not act as carrier"},
+		{"vote on incomplete policy", "incomplete", carrierRole, "carrierA", "castVote", []string{"ID", "carrierA", "approve"}, codeWrongStage, "is incomplete"},
+		{"modify with 4 args", "active", carrierRole, "carrierA", "modifyPolicy", []string{"ID", "carrierA", "US", "150"}, codeInvalidArgument, "Expected 5 or 6 arguments"},
+		{"modify pending policy", "pending", carrierRole, "carrierA", "modifyPolicy", []string{"ID", "carrierA", "US", "150", "1000"}, codeWrongStage, "is pending"},
+		{"modify country of another carrier", "active", carrierRole, "carrierA", "modifyPolicy", []string{"ID", "carrierA", "DE", "150", "1000"}, codeCarrierNotEligible, "not licensed"},
+		{"modify unchanged terms", "active", carrierRole, "carrierA", "modifyPolicy", []string{"ID", "carrierA", "US", "100", "1000"}, codeConflictingTerms, "not different"},
+		{"modify country not on policy", "active", carrierRole, "carrierA", "modifyPolicy", []string{"ID", "carrierA", "FR", "100", "1000"}, codeConflictingTerms, "carrier carrierA not found"},
+		{"get unknown policy", "", adminRole, "admin", "getPolicy", []string{"nope"}, codePolicyNotFound, "No policy found"},
+		{"get policy of another holder", "incomplete", holderRole, "other", "getPolicy", []string{"ID"}, codeUnauthorized, "may not act as holder"},
+		{"work queue of another carrier", "", carrierRole, "carrierA", "getPendingVotes", []string{"carrierB"}, codeUnauthorized, "may not act as carrier"},
+		{"list another holder's policies", "", holderRole, "acme", "listPolicies", []string{`{"stage": "active"}`}, codeUnauthorized, "may not act as holder"},
+		{"list unknown stage", "", adminRole, "admin", "listPolicies", []string{`{"stage": "archived"}`}, codeInvalidArgument, "stage: must be one of"},
+		{"generate backdated policy", "", holderRole, "acme", "generatePolicy", []string{`{"holderID": "acme", "countries": ["US"], "effectiveDate": "2016-12-01"}`}, codeInvalidArgument, "effectiveDate: must not be before"},
+		{"generate with bad date", "", holderRole, "acme", "generatePolicy", []string{`{"holderID": "acme", "countries": ["US"], "expiryDate": "next year"}`}, codeInvalidArgument, "expiryDate: must be a date"},
+		{"assign terms beyond policy", "incomplete", carrierRole, "carrierA", "assignTerms", []string{`{"policyID": "ID", "carrier": "carrierA", "country": "US", "premium": 1, "value": 1, "expiryDate": "2020-01-01"}`}, codeInvalidArgument, "expiryDate: must not be after the policy's expiry date"},
+		{"renew incomplete policy", "incomplete", holderRole, "acme", "renewPolicy", []string{"ID"}, codeWrongStage, "is incomplete"},
+		{"confirm without proposed terms", "incomplete", carrierRole, "carrierA", "confirmTerms", []string{"ID", "carrierA"}, codeInvalidArgument, "no proposed terms"},
+		{"carrier cancels whole policy", "active", carrierRole, "carrierA", "cancelPolicy", []string{"ID", "carrierWithdrawal", "2017-06-01"}, codeUnauthorized, "may only cancel the terms for a country"},
+		{"cancel with unknown reason", "active", holderRole, "acme", "cancelPolicy", []string{"ID", "boredom", "2017-06-01"}, codeInvalidArgument, "reasonCode: must be one of"},
+		{"cancel another carrier's terms", "active", carrierRole, "carrierA", "cancelPolicy", []string{"ID", "carrierWithdrawal", "2017-06-01", "DE"}, codeUnauthorized, "may not act as carrier"},
+		{"endorse with unknown action", "active", holderRole, "acme", "endorsePolicy", []string{"ID", "swapCountry", "FR"}, codeInvalidArgument, "action: must be"},
+		{"endorse covered country", "active", holderRole, "acme", "endorsePolicy", []string{"ID", "addCountry", "US"}, codeAlreadyExists, "already covers"},
+		{"endorse by carrier", "active", carrierRole, "carrierA", "endorsePolicy", []string{"ID", "addCountry", "FR"}, codeUnauthorized, "may not call endorsePolicy"},
+		{"consent without modification", "active", holderRole, "acme", "consentToModification", []string{"ID", "acme", "approve"}, codeWrongStage, "no modification pending"},
+		{"withdraw without modification", "active", carrierRole, "carrierA", "withdrawModification", []string{"ID"}, codeWrongStage, "no modification pending"},
+		{"withdraw by holder", "active", holderRole, "acme", "withdrawModification", []string{"ID"}, codeUnauthorized, "may not call withdrawModification"},
+		{"consent for another holder", "active", holderRole, "acme", "consentToModification", []string{"ID", "other", "approve"}, codeUnauthorized, "may not act as holder"},
+		{"get unknown version", "active", holderRole, "acme", "getPolicyVersion", []string{"ID", "9"}, codeNotFound, "No version 9"},
+		{"diff with bad version", "active", holderRole, "acme", "diffPolicyVersions", []string{"ID", "0", "5"}, codeInvalidArgument, "Invalid version"},
+		{"cancel with bad expected version", "active", holderRole, "acme", "cancelPolicy", []string{"ID", "holderRequest", "2017-06-01", "", "0"}, codeInvalidArgument, "expectedVersion: must be a positive integer"},
+		{"endorse with bad expected version", "active", holderRole, "acme", "endorsePolicy", []string{`{"policyID": "ID", "action": "addCountry", "country": "FR", "expectedVersion": -1}`}, codeInvalidArgument, "expectedVersion: must be a positive integer"},
+		{"assign with bad expected version", "incomplete", carrierRole, "carrierA", "assignTerms", []string{"ID", "carrierA", "US", "100", "1000", "latest"}, codeInvalidArgument, "expectedVersion: must be a positive integer"},
+		{"modify stale version", "active", carrierRole, "carrierA", "modifyPolicy", []string{"ID", "carrierA", "US", "150", "1000", "4"}, codeVersionConflict, "is at version 5, not 4"},
+		{"claim on pending policy", "pending", holderRole, "acme", "fileClaim", []string{"ID", "US", "100", "2017-01-01"}, codeWrongStage, "is pending"},
+		{"claim for another holder", "active", holderRole, "other", "fileClaim", []string{"ID", "US", "100", "2017-01-01"}, codeUnauthorized, "may not act as holder"},
+		{"claim country not on policy", "active", holderRole, "acme", "fileClaim", []string{"ID", "FR", "100", "2017-01-01"}, codeInvalidArgument, "has no terms for country"},
+		{"claim bad amount", "active", holderRole, "acme", "fileClaim", []string{"ID", "US", "-5", "2017-01-01"}, codeInvalidArgument, "Invalid claim amount"},
+		{"claim future incident", "active", holderRole, "acme", "fileClaim", []string{"ID", "US", "100", "2017-06-01"}, codeInvalidArgument, "in the future"},
+		{"claim before terms", "active", holderRole, "acme", "fileClaim", []string{"ID", "US", "100", "2016-12-31"}, codeInvalidArgument, "were in force"},
+		{"adjudicate unknown claim", "", carrierRole, "carrierA", "adjudicateClaim", []string{"nope", "carrierA", "accept", "0", ""}, codeNotFound, "No claim found"},
+		{"history of unknown policy", "", adminRole, "admin", "getPolicyHistory", []string{"nope"}, codePolicyNotFound, "No history found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLedger(t)
+			var policy Policy
+			if test.stage == "incomplete" {
+				policy = incompletePolicy(t, l)
+			} else if test.stage == "pending" {
+				policy = pendingPolicy(t, l)
+			} else if test.stage == "active" {
+				policy = activePolicy(t, l)
+			}
+
+			args := make([]string, len(test.args))
+			for i, arg := range test.args {
+				if arg == "ID" {
+					args[i] = policy.ID
+				} else {
+					args[i] = strings.Replace(arg, `"ID"`, `"` + policy.ID + `"`, 1)
+				}
+			}
+
+			l.as(test.role, test.orgID)
+			var err error
+			if strings.HasPrefix(test.function, "get") {
+				_, err = l.query(test.function, args...)
+			} else if test.function == "init" {
+				_, err = l.init()
+			} else {
+				_, err = l.invoke(test.function, args...)
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %s", test.code)
+			}
+			chaincodeError := asChaincodeError(err)
+			if chaincodeError.Code != test.code || !strings.Contains(chaincodeError.Message, test.want) {
+				t.Fatalf("got error %v, want %s containing %q", err, test.code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Stable error codes. Clients should branch on the code, never on the
+// message, which is meant for people and may change.
+var codePolicyNotFound = "POLICY_NOT_FOUND"
+var codeNotFound = "NOT_FOUND"
+var codeWrongStage = "WRONG_STAGE"
+var codeInvalidArgument = "INVALID_ARGUMENT"
+var codeUnauthorized = "UNAUTHORIZED"
+var codeDuplicateVote = "DUPLICATE_VOTE"
+var codeConflictingTerms = "CONFLICTING_TERMS"
+var codeAlreadyExists = "ALREADY_EXISTS"
+var codeCarrierNotEligible = "CARRIER_NOT_ELIGIBLE"
+var codeUnknownFunction = "UNKNOWN_FUNCTION"
+var codeVersionConflict = "VERSION_CONFLICT"
+var codeInternal = "INTERNAL"
+
+// ChaincodeError is the error returned to clients. Its Error method renders
+// it as JSON, which becomes the message of the error response.
+type ChaincodeError struct {
+	Code string `json:"code"`
+	Message string `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func newError(code string, message string) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: message}
+}
+
+// with adds a detail to the error and returns it, so that details can be
+// chained onto newError.
+func (e *ChaincodeError) with(key string, value interface{}) *ChaincodeError {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+func (e *ChaincodeError) Error() string {
+	errorAsBytes, err := json.Marshal(e)
+	if err != nil {
+		return e.Code + ": " + e.Message
+	}
+	return string(errorAsBytes)
+}
+
+// asChaincodeError returns err as a ChaincodeError, treating any other error,
+// such as a failure to read the ledger, as internal.
+func asChaincodeError(err error) *ChaincodeError {
+	chaincodeError, ok := err.(*ChaincodeError)
+	if ok {
+		return chaincodeError
+	}
+	return newError(codeInternal, err.Error())
+}
+
+func argumentCountError(expected string, received int) *ChaincodeError {
+	return newError(codeInvalidArgument, "Expected " + expected + "; arguments received: " + strconv.Itoa(received)).with("received", received)
+}
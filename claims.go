@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Each claim is stored under claim~<id>. The claims filed against a policy
+// are indexed under policyClaim~<policyID>~<claimID>. A claim is covered by
+// the terms for its country in force on the incident date, and the approved
+// amounts of a carrier's claims for a country are capped together.
+var claimKeyPrefix = "claim~"
+var policyClaimKeyPrefix = "policyClaim~"
+
+var claimFiled = "filed"
+var claimAccepted = "accepted"
+var claimPartial = "partial"
+var claimDenied = "denied"
+var claimPaid = "paid"
+
+var acceptDecision = "accept"
+var partialDecision = "partial"
+var denyDecision = "deny"
+
+var dateLayout = "2006-01-02"
+
+func claimKey(id string) string {
+	return claimKeyPrefix + id
+}
+
+func policyClaimKey(policyID string, claimID string) string {
+	return policyClaimKeyPrefix + policyID + "~" + claimID
+}
+
+func readClaim(stub LedgerStub, claimID string) (Claim, error) {
+	fmt.Println("Function: readClaim")
+
+	var claim Claim
+	claimAsBytes, err := stub.GetState(claimKey(claimID))
+	if err != nil {
+		return claim, err
+	}
+	if claimAsBytes == nil {
+		return claim, newError(codeNotFound, "No claim found with ID: " + claimID).with("claimID", claimID)
+	}
+
+	err = json.Unmarshal(claimAsBytes, &claim)
+	return claim, err
+}
+
+func writeClaim(stub LedgerStub, claim Claim) error {
+	fmt.Println("Function: writeClaim")
+
+	claimAsBytes, err := json.Marshal(claim)
+	if err != nil {
+		return err
+	}
+
+	return write(stub, claimKey(claim.ID), claimAsBytes)
+}
+
+func readPolicyClaims(stub LedgerStub, policyID string) (AllClaims, error) {
+	fmt.Println("Function: readPolicyClaims")
+
+	var claims AllClaims
+	claims.Catalog = make([]Claim, 0)
+
+	startKey, endKey := prefixRange(policyClaimKey(policyID, ""))
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return claims, err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, claimID, err := iter.Next()
+		if err != nil {
+			return claims, err
+		}
+
+		claim, err := readClaim(stub, string(claimID))
+		if err != nil {
+			return claims, err
+		}
+		claims.Catalog = append(claims.Catalog, claim)
+	}
+	return claims, nil
+}
+
+// countryTerms returns every terms a policy has been in force on for country,
+// in the order they were approved, read from its stored versions. Terms
+// replaced by a modification stay in force for the incidents of their period;
+// those of a removed country only until their removal.
+func countryTerms(stub LedgerStub, policyID string, country string) ([]CarrierTerms, error) {
+	fmt.Println("Function: countryTerms")
+
+	terms := make([]CarrierTerms, 0)
+	latest, err := readLatestVersion(stub, policyID)
+	if err != nil {
+		return terms, err
+	}
+
+	version := 1
+	for version <= latest {
+		policy, err := readPolicyVersion(stub, policyID, version)
+		if err != nil {
+			return terms, err
+		}
+		if policy.Status == activeStage || policy.Status == expiredStage || policy.Status == cancelledStage {
+			versionTerms := append(append([]CarrierTerms{}, policy.Terms...), policy.RemovedTerms...)
+			i := 0
+			for i < len(versionTerms) {
+				if versionTerms[i].Country == country && versionTerms[i].ID != "" {
+					// A later version of the same terms carries any cancellation
+					found := false
+					j := 0
+					for j < len(terms) {
+						if terms[j].ID == versionTerms[i].ID {
+							terms[j] = versionTerms[i]
+							found = true
+						}
+						j = j + 1
+					}
+					if !found {
+						terms = append(terms, versionTerms[i])
+					}
+				}
+				i = i + 1
+			}
+		}
+		version = version + 1
+	}
+	return terms, nil
+}
+
+// termsInForce returns the most recently approved of terms whose period
+// covers date, or terms with no ID if none does.
+func termsInForce(terms []CarrierTerms, date string) CarrierTerms {
+	i := len(terms) - 1
+	for i >= 0 {
+		t := terms[i]
+		if (t.EffectiveDate == "" || date >= t.EffectiveDate) && (t.ExpiryDate == "" || date <= t.ExpiryDate) && (t.CancellationDate == "" || date < t.CancellationDate) {
+			return t
+		}
+		i = i - 1
+	}
+	return CarrierTerms{}
+}
+
+// approvedForCoverage sums the amounts approved on every other claim made
+// against the carrier's terms for the same country, which together may not
+// exceed the value of the terms in force.
+func approvedForCoverage(stub LedgerStub, claim Claim) (int64, error) {
+	fmt.Println("Function: approvedForCoverage")
+
+	claims, err := readPolicyClaims(stub, claim.PolicyID)
+	if err != nil {
+		return 0, err
+	}
+
+	var approved int64
+	i := 0
+	for i < len(claims.Catalog) {
+		other := claims.Catalog[i]
+		if other.ID != claim.ID && other.CarrierID == claim.CarrierID && other.Country == claim.Country && other.Adjudication != nil {
+			approved = approved + other.Adjudication.ApprovedAmount
+		}
+		i = i + 1
+	}
+	return approved, nil
+}
+
+// readClaimablePolicy returns the active, expired or cancelled record of a
+// policy. Claims may still be made against an expired or cancelled policy for
+// incidents while it was in force.
+func readClaimablePolicy(stub LedgerStub, policyID string) (Policy, error) {
+	fmt.Println("Function: readClaimablePolicy")
+
+	return readPolicyInStages(stub, []string{activeStage, expiredStage, cancelledStage}, policyID)
+}
+
+func fileClaim(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: fileClaim")
+
+	// args are the policy ID, country, amount, incident date and any number
+	// of supporting document hashes
+	if len(args) < 4 {
+		return nil, argumentCountError("at least 4 arguments", len(args))
+	}
+
+	policy, err := readClaimablePolicy(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, policy.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var claim Claim
+	claim.ID = makeHash(stub.GetTxID(), args)
+	claim.PolicyID = policy.ID
+	claim.HolderID = policy.HolderID
+	claim.Country = args[1]
+	claim.Status = claimFiled
+	claim.Documents = args[4:]
+	claim.Payouts = make([]Payout, 0)
+
+	claim.Amount, err = strconv.ParseInt(args[2], 10, 64)
+	if err != nil || claim.Amount <= 0 {
+		return nil, newError(codeInvalidArgument, "Invalid claim amount: " + args[2]).with("field", "amount")
+	}
+
+	incidentDate, err := time.Parse(dateLayout, args[3])
+	if err != nil {
+		return nil, newError(codeInvalidArgument, "Invalid incident date: " + args[3]).with("field", "incidentDate")
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	if incidentDate.Unix() > now {
+		return nil, newError(codeInvalidArgument, "Incident date is in the future: " + args[3]).with("field", "incidentDate")
+	}
+	claim.IncidentDate = args[3]
+
+	allTerms, err := countryTerms(stub, policy.ID, claim.Country)
+	if err != nil {
+		return nil, err
+	}
+	if len(allTerms) == 0 {
+		return nil, newError(codeInvalidArgument, "Policy " + policy.ID + " has no terms for country: " + claim.Country).with("policyID", policy.ID).with("country", claim.Country)
+	}
+	terms := termsInForce(allTerms, claim.IncidentDate)
+	if terms.ID == "" {
+		return nil, newError(codeInvalidArgument, "No terms for " + claim.Country + " were in force on the incident date: " + args[3]).with("field", "incidentDate")
+	}
+	claim.CarrierID = terms.CarrierID
+	claim.TermsID = terms.ID
+
+	_, err = readClaim(stub, claim.ID)
+	if err == nil {
+		return nil, newError(codeAlreadyExists, "Claim already exists with ID: " + claim.ID).with("claimID", claim.ID)
+	}
+
+	err = writeClaim(stub, claim)
+	if err != nil {
+		return nil, err
+	}
+	err = write(stub, policyClaimKey(policy.ID, claim.ID), []byte(claim.ID))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("claim " + claim.ID + " filed against policy " + policy.ID)
+
+	return []byte(claim.ID), nil
+}
+
+func adjudicateClaim(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: adjudicateClaim")
+
+	// args are the claim ID, carrier ID, decision, approved amount and reason
+	if len(args) != 5 {
+		return nil, argumentCountError("5 arguments", len(args))
+	}
+
+	carrierID := args[1]
+	decision := args[2]
+	reason := args[4]
+
+	err := checkActingAs(stub, carrierRole, carrierID)
+	if err != nil {
+		return nil, err
+	}
+
+	claim, err := readClaim(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if claim.CarrierID != carrierID {
+		return nil, newError(codeUnauthorized, "Carrier " + carrierID + " does not cover claim " + claim.ID).with("carrier", carrierID).with("claimID", claim.ID)
+	}
+	if claim.Status != claimFiled {
+		return nil, newError(codeWrongStage, "Claim " + claim.ID + " has already been adjudicated").with("claimID", claim.ID).with("status", claim.Status)
+	}
+
+	var adjudication Adjudication
+	adjudication.CarrierID = carrierID
+	adjudication.Decision = decision
+	adjudication.Reason = reason
+	adjudication.TxID = stub.GetTxID()
+	adjudication.Timestamp, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var status string
+	if decision == acceptDecision {
+		status = claimAccepted
+		adjudication.ApprovedAmount = claim.Amount
+	} else if decision == partialDecision {
+		status = claimPartial
+		adjudication.ApprovedAmount, err = strconv.ParseInt(args[3], 10, 64)
+		if err != nil || adjudication.ApprovedAmount <= 0 || adjudication.ApprovedAmount >= claim.Amount {
+			return nil, newError(codeInvalidArgument, "Invalid partial amount: " + args[3]).with("field", "approvedAmount")
+		}
+	} else if decision == denyDecision {
+		status = claimDenied
+		adjudication.ApprovedAmount = 0
+	} else {
+		return nil, newError(codeInvalidArgument, "Invalid decision: " + decision).with("field", "decision")
+	}
+
+	if decision != acceptDecision && reason == "" {
+		return nil, newError(codeInvalidArgument, "A reason is required to " + decision + " a claim").with("field", "reason")
+	}
+
+	// The terms may since have been replaced by a modification, so the
+	// value is that of the terms in force on the incident date
+	allTerms, err := countryTerms(stub, claim.PolicyID, claim.Country)
+	if err != nil {
+		return nil, err
+	}
+	terms := termsInForce(allTerms, claim.IncidentDate)
+	if terms.ID == "" || terms.CarrierID != claim.CarrierID {
+		return nil, newError(codeConflictingTerms, "Carrier " + claim.CarrierID + " has no terms for " + claim.Country + " in force on " + claim.IncidentDate).with("carrier", claim.CarrierID).with("country", claim.Country)
+	}
+
+	approved, err := approvedForCoverage(stub, claim)
+	if err != nil {
+		return nil, err
+	}
+	if approved + adjudication.ApprovedAmount > terms.Value {
+		return nil, newError(codeInvalidArgument, "Approved amount exceeds the remaining value of the " + claim.Country + " terms of carrier " + claim.CarrierID + ": " + strconv.FormatInt(terms.Value - approved, 10)).with("remaining", terms.Value - approved)
+	}
+	claim.TermsID = terms.ID
+
+	claim.Adjudication = &adjudication
+	claim.Status = status
+	err = writeClaim(stub, claim)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("claim " + claim.ID + " adjudicated: " + decision)
+	return nil, nil
+}
+
+func recordPayout(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: recordPayout")
+
+	// args are the claim ID, carrier ID, amount and payment reference
+	if len(args) != 4 {
+		return nil, argumentCountError("4 arguments", len(args))
+	}
+
+	carrierID := args[1]
+	err := checkActingAs(stub, carrierRole, carrierID)
+	if err != nil {
+		return nil, err
+	}
+
+	claim, err := readClaim(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if claim.CarrierID != carrierID {
+		return nil, newError(codeUnauthorized, "Carrier " + carrierID + " does not cover claim " + claim.ID).with("carrier", carrierID).with("claimID", claim.ID)
+	}
+	if claim.Status != claimAccepted && claim.Status != claimPartial {
+		return nil, newError(codeWrongStage, "Claim " + claim.ID + " is " + claim.Status + " and cannot be paid").with("claimID", claim.ID).with("status", claim.Status)
+	}
+
+	var payout Payout
+	payout.Amount, err = strconv.ParseInt(args[2], 10, 64)
+	if err != nil || payout.Amount <= 0 {
+		return nil, newError(codeInvalidArgument, "Invalid payout amount: " + args[2]).with("field", "amount")
+	}
+	payout.Reference = args[3]
+	payout.TxID = stub.GetTxID()
+	payout.Timestamp, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var paid int64
+	i := 0
+	for i < len(claim.Payouts) {
+		paid = paid + claim.Payouts[i].Amount
+		i = i + 1
+	}
+	if paid + payout.Amount > claim.Adjudication.ApprovedAmount {
+		return nil, newError(codeInvalidArgument, "Payout exceeds the approved amount remaining on claim " + claim.ID + ": " + strconv.FormatInt(claim.Adjudication.ApprovedAmount - paid, 10)).with("remaining", claim.Adjudication.ApprovedAmount - paid)
+	}
+
+	claim.Payouts = append(claim.Payouts, payout)
+	if paid + payout.Amount == claim.Adjudication.ApprovedAmount {
+		claim.Status = claimPaid
+	}
+
+	err = writeClaim(stub, claim)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("payout recorded for claim " + claim.ID)
+	return nil, nil
+}
+
+func getClaim(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getClaim")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	claim, err := readClaim(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, claim.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(claim)
+}
+
+func getClaimsByPolicy(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getClaimsByPolicy")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	policy, err := readClaimablePolicy(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, policy.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := readPolicyClaims(stub, policy.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(claims)
+}
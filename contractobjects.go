@@ -6,12 +6,89 @@ type Policy struct {
 	Countries []string `json:"countries"`
 	Terms []CarrierTerms `json:"terms"`
 	Votes []Approval `json:"votes"`
+	EffectiveDate string `json:"effectiveDate"`
+	ExpiryDate string `json:"expiryDate"`
+	Status string `json:"status"`
+	Rejections []Rejection `json:"rejections"`
+	PredecessorID string `json:"predecessorID"`
+	Cancellations []Cancellation `json:"cancellations"`
+	Endorsement *Endorsement `json:"endorsement"`
+	Modification *Modification `json:"modification"`
+	RemovedTerms []CarrierTerms `json:"removedTerms"`
+	Version int `json:"version"`
+}
+
+// Modification holds new terms proposed for an active policy, which stays in
+// force on its current terms until every carrier and the holder approve them.
+// Votes has one vote per terms of the policy, as Policy.Votes does.
+type Modification struct {
+	Terms CarrierTerms `json:"terms"`
+	Votes []Approval `json:"votes"`
+	HolderVote string `json:"holderVote"`
+	TxID string `json:"txID"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Endorsement describes the change to the countries of an active policy that
+// the version of it being quoted or voted on would make.
+type Endorsement struct {
+	Action string `json:"action"`
+	Country string `json:"country"`
+	TxID string `json:"txID"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Cancellation records the cancellation of a policy, or of the terms for one
+// country when Country is set, and the premium each carrier must return.
+type Cancellation struct {
+	Country string `json:"country"`
+	ReasonCode string `json:"reasonCode"`
+	EffectiveDate string `json:"effectiveDate"`
+	TxID string `json:"txID"`
+	Timestamp int64 `json:"timestamp"`
+	Refunds []Refund `json:"refunds"`
+}
+
+type Refund struct {
+	CarrierID string `json:"carrier"`
+	Country string `json:"country"`
+	TermsID string `json:"termsID"`
+	Premium int64 `json:"premium"`
+	UnusedDays int64 `json:"unusedDays"`
+	TotalDays int64 `json:"totalDays"`
+	Amount int64 `json:"amount"`
+}
+
+// Rejection records terms disapproved by a carrier, which were then removed
+// from the policy for the country to be quoted again, or a modification
+// disapproved by a carrier or by the holder.
+type Rejection struct {
+	CarrierID string `json:"carrier"`
+	HolderID string `json:"holderID"`
+	Country string `json:"country"`
+	TermsID string `json:"termsID"`
+	Reason string `json:"reason"`
+	TxID string `json:"txID"`
+	Timestamp int64 `json:"timestamp"`
 }
 
 type AllPolicies struct {
 	Catalog []Policy `json:"policies"`
 }
 
+type PolicyPage struct {
+	Policies []Policy `json:"policies"`
+	Total *int `json:"total,omitempty"`
+	Bookmark string `json:"bookmark"`
+}
+
+type PolicyDetail struct {
+	Policy Policy `json:"policy"`
+	Stage string `json:"stage"`
+	OutstandingCountries []string `json:"outstandingCountries"`
+	OutstandingVoters []string `json:"outstandingVoters"`
+}
+
 type Approval struct {
 	CarrierID string `json:"carrier"`
 	Vote string `json:"vote"`
@@ -19,11 +96,9 @@ type Approval struct {
 
 type PolicyHolder struct {
 	ID string `json:"id"`
-	Policies []Policy `json:"policies"`
-}
-
-type AllHolders struct {
-	Catalog []PolicyHolder
+	LegalName string `json:"legalName"`
+	DomicileCountry string `json:"domicileCountry"`
+	ContactRef string `json:"contactRef"`
 }
 
 type CarrierTerms struct {
@@ -32,4 +107,118 @@ type CarrierTerms struct {
 	Country string `json:"country"`
 	Premium int64 `json:"premium"`
 	Value int64 `json:"value"`
+	EffectiveDate string `json:"effectiveDate"`
+	ExpiryDate string `json:"expiryDate"`
+	CancellationDate string `json:"cancellationDate"`
+}
+
+type Carrier struct {
+	ID string `json:"id"`
+	Name string `json:"name"`
+	LicensedCountries []string `json:"licensedCountries"`
+	Status string `json:"status"`
+}
+
+type AllCarriers struct {
+	Catalog []Carrier `json:"carriers"`
+}
+
+type HistoryHead struct {
+	PolicyID string `json:"policyID"`
+	HolderID string `json:"holderID"`
+	Count int `json:"count"`
+}
+
+type HistoryEntry struct {
+	TxID string `json:"txID"`
+	Timestamp int64 `json:"timestamp"`
+	Caller string `json:"caller"`
+	CallerRole string `json:"callerRole"`
+	Function string `json:"function"`
+	PreviousStage string `json:"previousStage"`
+	NewStage string `json:"newStage"`
+	TermsDiff []TermsChange `json:"termsDiff"`
+}
+
+type TermsChange struct {
+	Country string `json:"country"`
+	Previous *CarrierTerms `json:"previous,omitempty"`
+	Current *CarrierTerms `json:"current,omitempty"`
+}
+
+// VersionDiff lists the countries whose carrier, premium or value differ
+// between two versions of a policy. Previous or Current is nil where the
+// country was not covered or not quoted.
+type VersionDiff struct {
+	PolicyID string `json:"policyID"`
+	From int `json:"from"`
+	To int `json:"to"`
+	Countries []CountryDiff `json:"countries"`
+}
+
+type CountryDiff struct {
+	Country string `json:"country"`
+	Previous *TermsValues `json:"previous,omitempty"`
+	Current *TermsValues `json:"current,omitempty"`
+}
+
+type TermsValues struct {
+	CarrierID string `json:"carrier"`
+	Premium int64 `json:"premium"`
+	Value int64 `json:"value"`
+}
+
+type HistoryPage struct {
+	Entries []HistoryEntry `json:"entries"`
+	Total int `json:"total"`
+	Bookmark string `json:"bookmark"`
+}
+
+type PolicyEvent struct {
+	Type string `json:"type"`
+	PolicyID string `json:"policyID"`
+	HolderID string `json:"holderID"`
+	Stage string `json:"stage"`
+	Carriers []string `json:"carriers"`
+	Countries []string `json:"countries"`
+}
+
+type PolicyEvents struct {
+	TxID string `json:"txID"`
+	Events []PolicyEvent `json:"events"`
+}
+
+type Claim struct {
+	ID string `json:"id"`
+	PolicyID string `json:"policyID"`
+	HolderID string `json:"holderID"`
+	Country string `json:"country"`
+	CarrierID string `json:"carrier"`
+	TermsID string `json:"termsID"`
+	Amount int64 `json:"amount"`
+	IncidentDate string `json:"incidentDate"`
+	Documents []string `json:"documents"`
+	Status string `json:"status"`
+	Adjudication *Adjudication `json:"adjudication,omitempty"`
+	Payouts []Payout `json:"payouts"`
+}
+
+type AllClaims struct {
+	Catalog []Claim `json:"claims"`
+}
+
+type Adjudication struct {
+	CarrierID string `json:"carrier"`
+	Decision string `json:"decision"`
+	ApprovedAmount int64 `json:"approvedAmount"`
+	Reason string `json:"reason"`
+	TxID string `json:"txID"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+type Payout struct {
+	Amount int64 `json:"amount"`
+	Reference string `json:"reference"`
+	TxID string `json:"txID"`
+	Timestamp int64 `json:"timestamp"`
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// An endorsement adds a country to an active policy or removes one. The
+// active policy stays in force while a copy carrying the endorsement is
+// quoted and voted on like a modification: a new country is opened for
+// quotes in the incomplete stage, and the carrier of a removed country votes
+// in the pending stage. Only the carriers affected vote; the terms the
+// endorsement leaves unchanged are approved from the start. Once approved the
+// copy replaces the active policy.
+var addCountryAction = "addCountry"
+var removeCountryAction = "removeCountry"
+
+// pendingChange returns the version of an active policy being quoted or
+// voted on, or nil if there is none.
+func pendingChange(stub LedgerStub, policyID string) (*Policy, error) {
+	fmt.Println("Function: pendingChange")
+
+	stages := []string{pendingStage, incompleteStage}
+	i := 0
+	for i < len(stages) {
+		policyAsBytes, err := stub.GetState(policyKey(stages[i], policyID))
+		if err != nil {
+			return nil, err
+		}
+		if policyAsBytes != nil {
+			var change Policy
+			err = json.Unmarshal(policyAsBytes, &change)
+			if err != nil {
+				return nil, err
+			}
+			return &change, nil
+		}
+		i = i + 1
+	}
+	return nil, nil
+}
+
+// withdrawPendingChange deletes the version of a policy being quoted or voted
+// on, if any, as the policy leaves the active stage.
+func withdrawPendingChange(stub LedgerStub, policyID string) error {
+	fmt.Println("Function: withdrawPendingChange")
+
+	change, err := pendingChange(stub, policyID)
+	if err != nil {
+		return err
+	}
+	if change == nil {
+		return nil
+	}
+
+	err = deletePolicy(stub, change.Status, policyID)
+	if err != nil {
+		return err
+	}
+	fmt.Println("change of policy " + policyID + " in the " + change.Status + " stage withdrawn")
+	return nil
+}
+
+func endorsePolicy(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: endorsePolicy")
+
+	request, err := parseEndorsementRequest(args)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := getPolicyByHash(stub, activeStage, request.PolicyID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, policy.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkExpectedVersion(policy, request.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkNotLapsed(stub, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := pendingChange(stub, policy.ID)
+	if err != nil {
+		return nil, err
+	}
+	if change != nil {
+		return nil, newError(codeWrongStage, "Policy " + policy.ID + " has a change in the " + change.Status + " stage").with("policyID", policy.ID).with("stage", change.Status)
+	}
+	if policy.Modification != nil {
+		return nil, newError(codeWrongStage, "Policy " + policy.ID + " has a modification pending").with("policyID", policy.ID).with("stage", activeStage)
+	}
+
+	termsIndex := -1
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].Country == request.Country {
+			termsIndex = i
+		}
+		i = i + 1
+	}
+
+	var endorsement Endorsement
+	endorsement.Action = request.Action
+	endorsement.Country = request.Country
+	endorsement.TxID = stub.GetTxID()
+	endorsement.Timestamp, err = txTimestamp(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	previousTerms := append([]CarrierTerms{}, policy.Terms...)
+	policy.Votes = make([]Approval, len(policy.Terms))
+	i = 0
+	for i < len(policy.Terms) {
+		policy.Votes[i] = Approval{CarrierID: policy.Terms[i].CarrierID, Vote: "approve"}
+		i = i + 1
+	}
+
+	carriers := make([]string, 0)
+	if request.Action == addCountryAction {
+		if termsIndex != -1 {
+			return nil, newError(codeAlreadyExists, "Policy " + policy.ID + " already covers country: " + request.Country).with("policyID", policy.ID).with("country", request.Country)
+		}
+		policy.Countries = append(append([]string{}, policy.Countries...), request.Country)
+		policy.Terms = append(policy.Terms, CarrierTerms{Country: request.Country})
+		policy.Votes = append(policy.Votes, Approval{})
+	} else {
+		if termsIndex == -1 {
+			return nil, newError(codeInvalidArgument, "Policy " + policy.ID + " does not cover country: " + request.Country).with("policyID", policy.ID).with("country", request.Country)
+		}
+		if len(policy.Terms) == 1 {
+			return nil, newError(codeInvalidArgument, "Policy " + policy.ID + " must cover at least one country; cancel it instead").with("policyID", policy.ID).with("country", request.Country)
+		}
+		// Cancelled terms no longer bind their carrier, and are removed
+		// without a vote
+		if policy.Terms[termsIndex].CancellationDate == "" {
+			policy.Votes[termsIndex] = Approval{}
+			carriers = append(carriers, policy.Terms[termsIndex].CarrierID)
+		}
+	}
+	policy.Endorsement = &endorsement
+	queueEvent(stub, endorsementRequestedEvent, activeStage, policy, carriers, []string{request.Country})
+
+	policy, err = applyTransition(stub, "endorsePolicy", previousTerms, policy)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("endorsement of policy " + policy.ID + " is " + policy.Status)
+	return nil, nil
+}
+
+// completeEndorsement applies the endorsement of an approved policy. The terms
+// of a removed country are cancelled from date and kept as RemovedTerms, so
+// that they cover no later incidents.
+func completeEndorsement(policy *Policy, date string) {
+	if policy.Endorsement == nil {
+		return
+	}
+
+	if policy.Endorsement.Action == removeCountryAction {
+		countries := make([]string, 0)
+		terms := make([]CarrierTerms, 0)
+		votes := make([]Approval, 0)
+		i := 0
+		for i < len(policy.Terms) {
+			if policy.Terms[i].Country != policy.Endorsement.Country {
+				terms = append(terms, policy.Terms[i])
+				votes = append(votes, policy.Votes[i])
+			} else {
+				removed := policy.Terms[i]
+				if removed.CancellationDate == "" {
+					removed.CancellationDate = date
+				}
+				policy.RemovedTerms = append(policy.RemovedTerms, removed)
+			}
+			i = i + 1
+		}
+		i = 0
+		for i < len(policy.Countries) {
+			if policy.Countries[i] != policy.Endorsement.Country {
+				countries = append(countries, policy.Countries[i])
+			}
+			i = i + 1
+		}
+		policy.Countries = countries
+		policy.Terms = terms
+		policy.Votes = votes
+	}
+	policy.Endorsement = nil
+}
+
+// withdrawEndorsement drops the endorsement of a policy whose carrier refused
+// to have its country removed. The policy's terms are those of the active
+// policy, which every carrier had approved.
+func withdrawEndorsement(policy *Policy, date string) {
+	i := 0
+	for i < len(policy.Votes) {
+		policy.Votes[i] = Approval{CarrierID: policy.Terms[i].CarrierID, Vote: "approve"}
+		i = i + 1
+	}
+	policy.Endorsement = nil
+}
+
+func removalRejected(policy Policy, date string) bool {
+	return policy.Endorsement != nil && policy.Endorsement.Action == removeCountryAction && votesCast(policy, date) && !votesApproved(policy, date)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// A transaction can set only one chaincode event, so the lifecycle events of
+// a transaction are queued by transaction ID and set together as a single
+// PolicyLifecycle event once the transaction has succeeded.
+var policyEventName = "PolicyLifecycle"
+
+var policyCreatedEvent = "PolicyCreated"
+var termsAssignedEvent = "TermsAssigned"
+var policyPendingEvent = "PolicyPending"
+var voteCastEvent = "VoteCast"
+var policyActivatedEvent = "PolicyActivated"
+var policyRejectedEvent = "PolicyRejected"
+var policyModifiedEvent = "PolicyModified"
+var policyExpiredEvent = "PolicyExpired"
+var policyRenewedEvent = "PolicyRenewed"
+var termsCancelledEvent = "TermsCancelled"
+var policyCancelledEvent = "PolicyCancelled"
+var endorsementRequestedEvent = "EndorsementRequested"
+var policyEndorsedEvent = "PolicyEndorsed"
+var endorsementRejectedEvent = "EndorsementRejected"
+var modificationApprovedEvent = "ModificationApproved"
+var modificationRejectedEvent = "ModificationRejected"
+var modificationWithdrawnEvent = "ModificationWithdrawn"
+
+var queuedEvents = make(map[string][]PolicyEvent)
+var queuedEventsLock sync.Mutex
+
+// policyCarriers returns each carrier that has written terms for policy once.
+func policyCarriers(policy Policy) []string {
+	carriers := make([]string, 0)
+
+	i := 0
+	for i < len(policy.Terms) {
+		carrierID := policy.Terms[i].CarrierID
+		found := carrierID == ""
+		j := 0
+		for j < len(carriers) && !found {
+			found = carriers[j] == carrierID
+			j = j + 1
+		}
+		if !found {
+			carriers = append(carriers, carrierID)
+		}
+		i = i + 1
+	}
+	return carriers
+}
+
+// queueEvent queues an event about policy for the current transaction. The
+// carriers and countries are those involved in the event.
+func queueEvent(stub LedgerStub, eventType string, stage string, policy Policy, carriers []string, countries []string) {
+	fmt.Println("Function: queueEvent (" + eventType + ")")
+
+	var event PolicyEvent
+	event.Type = eventType
+	event.PolicyID = policy.ID
+	event.HolderID = policy.HolderID
+	event.Stage = stage
+	event.Carriers = carriers
+	event.Countries = countries
+
+	queuedEventsLock.Lock()
+	defer queuedEventsLock.Unlock()
+	queuedEvents[stub.GetTxID()] = append(queuedEvents[stub.GetTxID()], event)
+}
+
+// flushEvents sets the events queued for the current transaction, if any.
+func flushEvents(stub LedgerStub) error {
+	fmt.Println("Function: flushEvents")
+
+	queuedEventsLock.Lock()
+	events := queuedEvents[stub.GetTxID()]
+	delete(queuedEvents, stub.GetTxID())
+	queuedEventsLock.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	var batch PolicyEvents
+	batch.TxID = stub.GetTxID()
+	batch.Events = events
+	batchAsBytes, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	return stub.SetEvent(policyEventName, batchAsBytes)
+}
+
+// discardEvents drops the events queued for a transaction that has failed.
+func discardEvents(stub LedgerStub) {
+	queuedEventsLock.Lock()
+	defer queuedEventsLock.Unlock()
+	delete(queuedEvents, stub.GetTxID())
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Policies and their terms run from their effective date to their expiry
+// date inclusive, both in dateLayout and compared with the date of the
+// transaction in UTC. Active policies are indexed by expiry date under
+// policyExpiry~<expiryDate>~<policyID>; policies that predate expiry dates
+// have none and do not expire.
+var policyExpiryKeyPrefix = "policyExpiry~"
+
+// A policy generated without dates runs for a year from the transaction date.
+var defaultPolicyYears = 1
+
+func policyExpiryKey(expiryDate string, policyID string) string {
+	return policyExpiryKeyPrefix + expiryDate + "~" + policyID
+}
+
+func expiryKeys(stage string, policy Policy) []string {
+	if stage != activeStage || policy.ExpiryDate == "" {
+		return []string{}
+	}
+	return []string{policyExpiryKey(policy.ExpiryDate, policy.ID)}
+}
+
+// txDate returns the date of the transaction, at midnight UTC.
+func txDate(stub LedgerStub) (time.Time, error) {
+	seconds, err := txTimestamp(stub)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Unix(seconds, 0).UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+func periodError(field string, message string) *ChaincodeError {
+	return newError(codeInvalidArgument, field + ": " + message).with("field", field)
+}
+
+// setPolicyPeriod defaults the dates of a new policy and checks that it does
+// not take effect before the transaction date.
+func setPolicyPeriod(stub LedgerStub, policy *Policy) error {
+	fmt.Println("Function: setPolicyPeriod")
+
+	today, err := txDate(stub)
+	if err != nil {
+		return err
+	}
+
+	if policy.EffectiveDate == "" {
+		policy.EffectiveDate = today.Format(dateLayout)
+	}
+	if policy.ExpiryDate == "" {
+		effective, _ := time.Parse(dateLayout, policy.EffectiveDate)
+		policy.ExpiryDate = effective.AddDate(defaultPolicyYears, 0, 0).Format(dateLayout)
+	}
+
+	if policy.EffectiveDate < today.Format(dateLayout) {
+		return periodError("effectiveDate", "must not be before the transaction date " + today.Format(dateLayout))
+	}
+	if policy.ExpiryDate <= policy.EffectiveDate {
+		return periodError("expiryDate", "must be after the effective date " + policy.EffectiveDate)
+	}
+	return nil
+}
+
+// setTermsPeriod defaults the dates of terms to those of the terms they
+// replace, if any, or else to those of the policy, and checks that they fall
+// within the policy's period and have not already expired.
+func setTermsPeriod(stub LedgerStub, policy Policy, terms *CarrierTerms, replaced *CarrierTerms) error {
+	fmt.Println("Function: setTermsPeriod")
+
+	if replaced != nil && replaced.ID != "" {
+		if terms.EffectiveDate == "" {
+			terms.EffectiveDate = replaced.EffectiveDate
+		}
+		if terms.ExpiryDate == "" {
+			terms.ExpiryDate = replaced.ExpiryDate
+		}
+	}
+	if terms.EffectiveDate == "" {
+		terms.EffectiveDate = policy.EffectiveDate
+	}
+	if terms.ExpiryDate == "" {
+		terms.ExpiryDate = policy.ExpiryDate
+	}
+
+	// Terms of policies that predate expiry dates are not bounded
+	if policy.EffectiveDate == "" {
+		return nil
+	}
+
+	today, err := txDate(stub)
+	if err != nil {
+		return err
+	}
+
+	if terms.EffectiveDate < policy.EffectiveDate {
+		return periodError("effectiveDate", "must not be before the policy's effective date " + policy.EffectiveDate)
+	}
+	if terms.ExpiryDate > policy.ExpiryDate {
+		return periodError("expiryDate", "must not be after the policy's expiry date " + policy.ExpiryDate)
+	}
+	if terms.ExpiryDate <= terms.EffectiveDate {
+		return periodError("expiryDate", "must be after the effective date " + terms.EffectiveDate)
+	}
+	if terms.ExpiryDate < today.Format(dateLayout) {
+		return periodError("expiryDate", "must not be before the transaction date " + today.Format(dateLayout))
+	}
+	return nil
+}
+
+// checkNotLapsed rejects changes to a policy whose expiry date has passed.
+func checkNotLapsed(stub LedgerStub, policy Policy) error {
+	fmt.Println("Function: checkNotLapsed")
+
+	if policy.ExpiryDate == "" {
+		return nil
+	}
+
+	today, err := txDate(stub)
+	if err != nil {
+		return err
+	}
+	if policy.ExpiryDate < today.Format(dateLayout) {
+		return newError(codeWrongStage, "Policy " + policy.ID + " expired on " + policy.ExpiryDate).with("policyID", policy.ID).with("expiryDate", policy.ExpiryDate)
+	}
+	return nil
+}
+
+// expirePolicies moves every active policy whose expiry date has passed to
+// the expired stage, and every active policy whose terms are all cancelled
+// from the transaction date or earlier to the cancelled stage, withdrawing
+// any change of it still under review, and returns their IDs.
+func expirePolicies(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: expirePolicies")
+
+	if len(args) != 0 {
+		return nil, argumentCountError("no arguments", len(args))
+	}
+
+	today, err := txDate(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	lapsed, err := indexedPolicyIDs(stub, policyCancellationKeyPrefix, policyCancellationKey(today.AddDate(0, 0, 1).Format(dateLayout), ""), []string{})
+	if err != nil {
+		return nil, err
+	}
+	lapsed, err = indexedPolicyIDs(stub, policyExpiryKeyPrefix, policyExpiryKey(today.Format(dateLayout), ""), lapsed)
+	if err != nil {
+		return nil, err
+	}
+
+	i := 0
+	for i < len(lapsed) {
+		policy, err := getPolicyByHash(stub, activeStage, lapsed[i])
+		if err != nil {
+			return nil, err
+		}
+
+		err = withdrawPendingChange(stub, policy.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = applyTransition(stub, "expirePolicies", policy.Terms, policy)
+		if err != nil {
+			return nil, err
+		}
+		i = i + 1
+	}
+
+	return json.Marshal(lapsed)
+}
+
+// indexedPolicyIDs appends to policyIDs those of the policies indexed from
+// startKey up to endKey that it does not already hold.
+func indexedPolicyIDs(stub LedgerStub, startKey string, endKey string, policyIDs []string) ([]string, error) {
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, policyID, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		i := 0
+		for i < len(policyIDs) {
+			if policyIDs[i] == string(policyID) {
+				found = true
+			}
+			i = i + 1
+		}
+		if !found {
+			policyIDs = append(policyIDs, string(policyID))
+		}
+	}
+	return policyIDs, nil
+}
+
+// getExpiringPolicies returns the active policies expiring within the given
+// number of days of the transaction date, soonest first.
+func getExpiringPolicies(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getExpiringPolicies")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days < 0 {
+		return nil, newError(codeInvalidArgument, "Invalid number of days: " + args[0]).with("field", "days")
+	}
+
+	today, err := txDate(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies AllPolicies
+	policies.Catalog = make([]Policy, 0)
+
+	startKey := policyExpiryKey(today.Format(dateLayout), "")
+	endKey := policyExpiryKey(today.AddDate(0, 0, days + 1).Format(dateLayout), "")
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, policyID, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		policy, err := getPolicyByHash(stub, activeStage, string(policyID))
+		if err != nil {
+			return nil, err
+		}
+		policies.Catalog = append(policies.Catalog, policy)
+	}
+
+	return json.Marshal(policies)
+}
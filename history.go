@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// The history of a policy is append-only. Its entries are stored under
+// history~<policyID>~<seq>, numbered from zero, and historyHead~<policyID>
+// records the holder of the policy and the number of entries.
+var historyKeyPrefix = "history~"
+var historyHeadKeyPrefix = "historyHead~"
+
+var defaultHistoryPageSize = 50
+
+func historyKey(policyID string, seq int) string {
+	return historyKeyPrefix + policyID + "~" + fmt.Sprintf("%010d", seq)
+}
+
+func historyHeadKey(policyID string) string {
+	return historyHeadKeyPrefix + policyID
+}
+
+func readHistoryHead(stub LedgerStub, policyID string) (HistoryHead, error) {
+	fmt.Println("Function: readHistoryHead")
+
+	var head HistoryHead
+	headAsBytes, err := stub.GetState(historyHeadKey(policyID))
+	if err != nil {
+		return head, err
+	}
+	if headAsBytes == nil {
+		return head, newError(codePolicyNotFound, "No history found for policy: " + policyID).with("policyID", policyID)
+	}
+
+	err = json.Unmarshal(headAsBytes, &head)
+	return head, err
+}
+
+// diffTerms lists the countries whose terms differ between previous and
+// current, including in their dates. Terms are compared by country, as
+// endorsements move them.
+func diffTerms(previous []CarrierTerms, current []CarrierTerms) []TermsChange {
+	changes := make([]TermsChange, 0)
+
+	slots := append(append([]CarrierTerms{}, previous...), current...)
+	seen := make(map[string]bool)
+	i := 0
+	for i < len(slots) {
+		country := slots[i].Country
+		if seen[country] {
+			i = i + 1
+			continue
+		}
+		seen[country] = true
+
+		var change TermsChange
+		change.Country = country
+		j := 0
+		for j < len(previous) {
+			if previous[j].Country == country && previous[j].ID != "" {
+				terms := previous[j]
+				change.Previous = &terms
+			}
+			j = j + 1
+		}
+		j = 0
+		for j < len(current) {
+			if current[j].Country == country && current[j].ID != "" {
+				terms := current[j]
+				change.Current = &terms
+			}
+			j = j + 1
+		}
+		if change.Previous != nil || change.Current != nil {
+			if change.Previous == nil || change.Current == nil || *change.Previous != *change.Current {
+				changes = append(changes, change)
+			}
+		}
+		i = i + 1
+	}
+	return changes
+}
+
+// recordHistory appends an entry to the history of policy. An empty stage
+// means the policy was not stored before (creation) or after (removal).
+func recordHistory(stub LedgerStub, function string, previousStage string, newStage string, previousTerms []CarrierTerms, policy Policy) error {
+	fmt.Println("Function: recordHistory")
+
+	var head HistoryHead
+	headAsBytes, err := stub.GetState(historyHeadKey(policy.ID))
+	if err != nil {
+		return err
+	}
+	if headAsBytes == nil {
+		head.PolicyID = policy.ID
+		head.HolderID = policy.HolderID
+	} else {
+		err = json.Unmarshal(headAsBytes, &head)
+		if err != nil {
+			return err
+		}
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+
+	var entry HistoryEntry
+	entry.TxID = stub.GetTxID()
+	entry.Timestamp, err = txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+	entry.Caller = caller.OrgID
+	entry.CallerRole = caller.Role
+	entry.Function = function
+	entry.PreviousStage = previousStage
+	entry.NewStage = newStage
+	entry.TermsDiff = diffTerms(previousTerms, policy.Terms)
+
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = write(stub, historyKey(policy.ID, head.Count), entryAsBytes)
+	if err != nil {
+		return err
+	}
+
+	head.Count = head.Count + 1
+	headAsBytes, err = json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	err = write(stub, historyHeadKey(policy.ID), headAsBytes)
+	if err != nil {
+		return err
+	}
+	fmt.Println("history entry " + strconv.Itoa(head.Count - 1) + " recorded for policy " + policy.ID)
+	return nil
+}
+
+// getPolicyHistory returns a page of a policy's history, oldest first. args
+// are the policy ID and optionally a page size and the bookmark returned
+// with the previous page.
+func getPolicyHistory(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getPolicyHistory")
+
+	if len(args) < 1 || len(args) > 3 {
+		return nil, argumentCountError("1 to 3 arguments", len(args))
+	}
+
+	var err error
+	pageSize := defaultHistoryPageSize
+	if len(args) > 1 {
+		pageSize, err = strconv.Atoi(args[1])
+		if err != nil || pageSize < 1 {
+			return nil, newError(codeInvalidArgument, "Invalid page size: " + args[1]).with("field", "pageSize")
+		}
+	}
+	start := 0
+	if len(args) > 2 && args[2] != "" {
+		start, err = strconv.Atoi(args[2])
+		if err != nil || start < 0 {
+			return nil, newError(codeInvalidArgument, "Invalid bookmark: " + args[2]).with("field", "bookmark")
+		}
+	}
+
+	head, err := readHistoryHead(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, head.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var page HistoryPage
+	page.Entries = make([]HistoryEntry, 0)
+
+	seq := start
+	for seq < head.Count && seq < start + pageSize {
+		entryAsBytes, err := stub.GetState(historyKey(head.PolicyID, seq))
+		if err != nil {
+			return nil, err
+		}
+
+		var entry HistoryEntry
+		err = json.Unmarshal(entryAsBytes, &entry)
+		if err != nil {
+			return nil, err
+		}
+		page.Entries = append(page.Entries, entry)
+		seq = seq + 1
+	}
+
+	if seq < head.Count {
+		page.Bookmark = strconv.Itoa(seq)
+	}
+	page.Total = head.Count
+
+	return json.Marshal(page)
+}
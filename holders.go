@@ -1,22 +1,189 @@
 package main
 
 import (
-	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"encoding/json"
+	"fmt"
 )
 
-func registerNewHolder(stub *shim.ChaincodeStub, args []string) error {
-	//TODO(jacob): Check that holder does not yet exist
+// Each holder is stored under holder~<id>. The policies generated for a
+// holder are indexed under holderPolicy~<holderID>~<policyID>.
+var holderKeyPrefix = "holder~"
+var holderPolicyKeyPrefix = "holderPolicy~"
 
-	//TODO(jacob): Add holder to allHolders
+func holderKey(id string) string {
+	return holderKeyPrefix + id
+}
+
+func holderPolicyKey(holderID string, policyID string) string {
+	return holderPolicyKeyPrefix + holderID + "~" + policyID
+}
+
+func registerNewHolder(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: registerNewHolder")
+
+	if len(args) != 4 {
+		return nil, argumentCountError("4 arguments", len(args))
+	}
+
+	var holder PolicyHolder
+	holder.ID = args[0]
+	holder.LegalName = args[1]
+	holder.DomicileCountry = args[2]
+	holder.ContactRef = args[3]
+
+	if holder.ID == "" || holder.LegalName == "" || holder.DomicileCountry == "" {
+		return nil, newError(codeInvalidArgument, "Holder ID, legal name and domicile country are required")
+	}
+	err := checkKeyPart("holderID", holder.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	holderAsBytes, err := stub.GetState(holderKey(holder.ID))
+	if err != nil {
+		return nil, err
+	}
+	if holderAsBytes != nil {
+		return nil, newError(codeAlreadyExists, "Holder already registered with ID: " + holder.ID).with("holderID", holder.ID)
+	}
+
+	holderAsBytes, err = json.Marshal(holder)
+	if err != nil {
+		return nil, err
+	}
+
+	err = write(stub, holderKey(holder.ID), holderAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("holder " + holder.ID + " registered")
+	return nil, nil
+}
+
+func readHolder(stub LedgerStub, holderID string) (PolicyHolder, error) {
+	fmt.Println("Function: readHolder")
+
+	var holder PolicyHolder
+	holderAsBytes, err := stub.GetState(holderKey(holderID))
+	if err != nil {
+		return holder, err
+	}
+	if holderAsBytes == nil {
+		return holder, newError(codeNotFound, "No holder registered with ID: " + holderID).with("holderID", holderID)
+	}
+
+	err = json.Unmarshal(holderAsBytes, &holder)
+	return holder, err
+}
+
+func getHolder(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getHolder")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
 
+	err := checkActingAs(stub, holderRole, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	holder, err := readHolder(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(holder)
+}
+
+func addPolicyToHolder(stub LedgerStub, policy Policy, holderID string) error {
+	fmt.Println("Function: addPolicyToHolder")
+
+	err := write(stub, holderPolicyKey(holderID, policy.ID), []byte(policy.ID))
+	if err != nil {
+		return err
+	}
+	fmt.Println("policy " + policy.ID + " indexed for holder " + holderID)
 	return nil
 }
 
-func getPoliciesByHolder(stub *shim.ChaincodeStub, holder string) []Policy {
-	//TODO(jacob): Check that holder has been registered
+// getPoliciesByHolder returns every stored record of the holder's policies.
+// A policy under endorsement appears once as active and once as incomplete or
+// pending.
+func getPoliciesByHolder(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getPoliciesByHolder")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	holderID := args[0]
+	err := checkActingAs(stub, holderRole, holderID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = readHolder(stub, holderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies AllPolicies
+	policies.Catalog = make([]Policy, 0)
 
-	//TODO(jacob): return policies held by holder
-	
-	var policies []Policy
-	return policies
+	startKey, endKey := prefixRange(holderPolicyKey(holderID, ""))
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, policyID, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		// A policy under endorsement is stored in two stages
+		i := 0
+		for i < len(policyStages) {
+			policy, err := readStoredPolicy(stub, policyStages[i], string(policyID))
+			if err != nil {
+				return nil, err
+			}
+			if policy != nil {
+				policies.Catalog = append(policies.Catalog, *policy)
+			}
+			i = i + 1
+		}
+	}
+
+	return json.Marshal(policies)
+}
+
+// migrateHolderPolicies indexes every stored policy for its holder, as the
+// policies of the legacy catalogs were never indexed.
+func migrateHolderPolicies(stub LedgerStub) error {
+	fmt.Println("Function: migrateHolderPolicies")
+
+	i := 0
+	for i < len(policyStages) {
+		policies, err := readPolicies(stub, policyStages[i])
+		if err != nil {
+			return err
+		}
+
+		j := 0
+		for j < len(policies.Catalog) {
+			if policies.Catalog[j].HolderID != "" {
+				err = addPolicyToHolder(stub, policies.Catalog[j], policies.Catalog[j].HolderID)
+				if err != nil {
+					return err
+				}
+			}
+			j = j + 1
+		}
+		i = i + 1
+	}
+	return nil
 }
@@ -1,21 +1,19 @@
 package main
 
 import(
-	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	"strconv"
 )
 
-func createPolicyObject(args []string) Policy {
+func createPolicyObject(txID string, request PolicyRequest) Policy {
 	fmt.Println("Function: createPolicyObject")
 	
 	var policy Policy
-	policy.ID = makeHash(args)
-	policy.HolderID = args[0]
+	policy.ID = makeHash(txID, request.args())
+	policy.HolderID = request.HolderID
+	policy.EffectiveDate = request.EffectiveDate
+	policy.ExpiryDate = request.ExpiryDate
 
-	countries := args[1:]
+	countries := request.Countries
 	policy.Countries = countries
 	policy.Terms = make([]CarrierTerms, len(countries))
 
@@ -28,129 +26,122 @@ func createPolicyObject(args []string) Policy {
 	return policy
 }
 
-func generatePolicy(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func generatePolicy(stub LedgerStub, args []string) ([]byte, error) {
 	fmt.Println("Function: generatePolicy")
 
-	if len(args) < 2 {
-		return nil, errors.New("Expected multiple arguments; arguments received: " +  strconv.Itoa(len(args)))
+	request, err := parsePolicyRequest(args)
+	if err != nil {
+		return nil, err
 	}
 
-	//TODO: check that policy holder has been registered
-	
-	newPolicy := createPolicyObject(args)
-	
-	// Retrieve the current list of pending policies
-	incompleteAsBytes, err := getPolicies(stub, incompletePoliciesString)
+	err = checkActingAs(stub, holderRole, request.HolderID)
 	if err != nil {
 		return nil, err
 	}
 
-	var incompletePolicies AllPolicies
-	incompletePolicies, err = bytesToAllPolicies(incompleteAsBytes)
+	_, err = readHolder(stub, request.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	newPolicy := createPolicyObject(stub.GetTxID(), request)
+
+	err = setPolicyPeriod(stub, &newPolicy)
 	if err != nil {
 		return nil, err
 	}
-	
-	// Add the new policy to the list of pending policies
-	incompletePolicies.Catalog = append(incompletePolicies.Catalog, newPolicy)
-	fmt.Println("New policy appended to incomplete policies. Incomplete policy count: " + strconv.Itoa(len(incompletePolicies.Catalog)))
 
-	err = writePolicies(stub, incompletePoliciesString, incompletePolicies)
+	err = checkPolicyIDUnused(stub, newPolicy.ID)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("incomplete policies successfully rewritten with new policy")
+
+	err = addPolicyToHolder(stub, newPolicy, newPolicy.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = applyTransition(stub, "generatePolicy", nil, newPolicy)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("new policy successfully written to incomplete policies")
 	return nil, nil
 }
 
-func createTerms(args []string) (CarrierTerms, error) {
+func createTerms(txID string, request TermsRequest) CarrierTerms {
 	fmt.Println("Function: createTerms")
 	
 	var terms CarrierTerms
-	if len(args) != 4 {
-		return terms, errors.New("Expected 4 arguments; arguments received: " + strconv.Itoa(len(args)))
-	}
-
-	var err error
-	terms.CarrierID = args[0]
-	terms.ID = makeHash(args)
-	terms.Country = args[1] 
-	terms.Premium, err = strconv.ParseInt(args[2], 10, 64)
-	terms.Value, err = strconv.ParseInt(args[3], 10, 64)
-	
-	return terms, err
+	terms.CarrierID = request.CarrierID
+	terms.ID = makeHash(txID, request.args())
+	terms.Country = request.Country
+	terms.Premium = *request.Premium
+	terms.Value = *request.Value
+	terms.EffectiveDate = request.EffectiveDate
+	terms.ExpiryDate = request.ExpiryDate
+
+	return terms
 }
 
-func assignTerms(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+func assignTerms(stub LedgerStub, args []string) ([]byte, error) {
 	fmt.Println("Function: assignTerms")
 	
-	// args should include the terms of the policy and the timestamp for the policy that it will be assigned to
-	if len(args) != 5 {
-		return nil, errors.New("Expecting 5 arguments; arguments received: " + strconv.Itoa(len(args)))
+	request, err := parseTermsRequest(args)
+	if err != nil {
+		return nil, err
 	}
 
-	incompleteAsBytes, err := getPolicies(stub, incompletePoliciesString)
+	policy, err := getPolicyByHash(stub, incompleteStage, request.PolicyID)
 	if err != nil {
 		return nil, err
 	}
 
-	var incompletePolicies AllPolicies
-	incompletePolicies, err = bytesToAllPolicies(incompleteAsBytes)
+	err = checkExpectedVersion(policy, request.ExpectedVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(incompletePolicies.Catalog) == 0 {
-		return nil, errors.New("No incomplete policies were found")
+	carrierTerms := createTerms(stub.GetTxID(), request)
+
+	err = checkActingAs(stub, carrierRole, carrierTerms.CarrierID)
+	if err != nil {
+		return nil, err
 	}
-	
-	policyHash := args[0]
-	var index int
-	index, err = getPolicyByHash(incompletePolicies.Catalog, policyHash)
+
+	err = checkNotLapsed(stub, policy)
 	if err != nil {
 		return nil, err
 	}
 
-	carrierArgs := args[1:]
-	var carrierTerms CarrierTerms
-	carrierTerms, err = createTerms(carrierArgs)
+	err = setTermsPeriod(stub, policy, &carrierTerms, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	err = insertTermsIntoPolicy(&incompletePolicies.Catalog[index], carrierTerms)
+	err = checkCarrierLicensed(stub, carrierTerms.CarrierID, carrierTerms.Country)
 	if err != nil {
 		return nil, err
 	}
 
-	err = checkComplete(incompletePolicies.Catalog[index])
+	previousTerms := append([]CarrierTerms{}, policy.Terms...)
+	err = insertTermsIntoPolicy(&policy, carrierTerms)
 	if err != nil {
-		incompleteAsBytes, err = json.Marshal(incompletePolicies)
-		if err != nil {
-			return nil, err
-		}
-		err = write(stub, incompletePoliciesString, incompleteAsBytes)
-		if err != nil {
-			return nil, err
-		}
-		fmt.Println("incomplete policies successfully written with new terms")
-		return nil, nil
+		return nil, err
 	}
 
-	pendingPolicy := removePolicy(&incompletePolicies, index)
-		
-	err = addPendingPolicy(stub, pendingPolicy)
+	err = reserveTermsID(stub, carrierTerms.ID, policy.ID)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("policy successfully added to pending policies")
-	fmt.Println("pendingPolicy removed from incomplete policies")
 
-	err = writePolicies(stub, incompletePoliciesString, incompletePolicies)
+	queueEvent(stub, termsAssignedEvent, incompleteStage, policy, []string{carrierTerms.CarrierID}, []string{carrierTerms.Country})
+
+	_, err = applyTransition(stub, "assignTerms", previousTerms, policy)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("incomplete policies successfully written")
+	fmt.Println("policy successfully written with new terms")
 	return nil, nil
 }
 
@@ -171,19 +162,5 @@ func insertTermsIntoPolicy(policy *Policy, terms CarrierTerms) error {
 		}
 		i = i + 1
 	}
-	return errors.New("Policy does not require country: " + terms.Country)
-}
-
-func checkComplete(policy Policy) error {
-	fmt.Println("Function: checkComplete")
-
-	i := 0
-	for i < len(policy.Terms) {
-		if policy.Terms[i].ID == "" {
-			return errors.New("Policy incomplete")
-		}
-		i = i + 1
-	}
-	fmt.Println("Policy complete")
-	return nil
+	return newError(codeInvalidArgument, "Policy does not require country: " + terms.Country).with("policyID", policy.ID).with("country", terms.Country)
 }
@@ -1,18 +1,20 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
 )
 
 type SimpleChaincode struct {}
 
+// Legacy single-key policy catalogs, read only by migrateCatalogs
 var incompletePoliciesString = "_incompletePolicies"
 var pendingPoliciesString = "_pendingPolicies"
 var activePoliciesString = "_activePolicies"
-var holdersString = "_holders"
 
 func main() {
 	fmt.Println("Function: main")
@@ -23,69 +25,121 @@ func main() {
 	}
 }
 
-func makeHash(args []string) string {
-	if len(args) < 0 {
-		return "no_hash_can_be_generated"
-	}
+// makeHash derives an ID from a SHA-256 over the length-prefixed args and the
+// transaction ID, so neither ambiguous concatenations such as ("ab", "c") and
+// ("a", "bc") nor identical args in separate transactions share an ID.
+func makeHash(txID string, args []string) string {
+	hash := sha256.New()
+	fields := append(append([]string{}, args...), txID)
 
 	i := 0
-	s := ""
-	for i < len(args){
-		s = s + args[i]
+	for i < len(fields) {
+		length := make([]byte, 8)
+		binary.BigEndian.PutUint64(length, uint64(len(fields[i])))
+		hash.Write(length)
+		hash.Write([]byte(fields[i]))
 		i = i + 1
 	}
-	return s
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// txTimestamp returns the transaction timestamp in seconds since the epoch.
+func txTimestamp(stub LedgerStub) (int64, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	return timestamp.Seconds, nil
 }
 
-func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) peer.Response {
 	fmt.Println("Method: SimpleChaincode.Init")
 
-	// Initialize the catalogs for both pending and active policies
-	incompleteCatalog := make([]Policy, 0)
-	pendingCatalog := make([]Policy, 0)
-	activeCatalog := make([]Policy, 0)
+	_, args := stub.GetFunctionAndParameters()
+	return toResponse(initLedger(shimLedger{stub}, args))
+}
 
-	//Create and marshal the active policies
-	var activePolicies AllPolicies
-	activePolicies.Catalog = activeCatalog
-	activeAsBytes, err := json.Marshal(activePolicies)
-	if err != nil {
-		return nil, err
-	}
-	
-	// Create and marshal the pending policies
-	var pendingPolicies AllPolicies
-	pendingPolicies.Catalog = pendingCatalog
-	var pendingAsBytes []byte
-	pendingAsBytes, err = json.Marshal(pendingPolicies)
-	if err != nil {
-		return nil, err
-	}
+func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	fmt.Println("Method: SimpleChaincode.Invoke")
 
-	// Create and marshal incomplete policies
-	var incompletePolicies AllPolicies
-	incompletePolicies.Catalog = incompleteCatalog
-	var incompleteAsBytes []byte
-	incompleteAsBytes, err = json.Marshal(incompletePolicies)
-	if err != nil {
-		return nil, err
-	}
+	function, args := stub.GetFunctionAndParameters()
+	return toResponse(invokeLedger(shimLedger{stub}, function, args))
+}
 
-	err = stub.PutState(activePoliciesString, activeAsBytes)
+func toResponse(result []byte, err error) peer.Response {
 	if err != nil {
-		fmt.Println("Failed to initialize policies")
-		return nil, err
+		return shim.Error(asChaincodeError(err).Error())
 	}
+	return shim.Success(result)
+}
+
+type chaincodeFunction func(stub LedgerStub, args []string) ([]byte, error)
+
+// chaincodeFunctions maps each function name accepted by Invoke, including
+// the read-only queries, to its handler.
+var chaincodeFunctions = map[string]chaincodeFunction{
+	"init": initLedger,
+	"generatePolicy": generatePolicy,
+	"assignTerms": assignTerms,
+	"castVote": castVote,
+	"modifyPolicy": modifyActivePolicy,
+	"registerHolder": registerNewHolder,
+	"registerCarrier": registerCarrier,
+	"setCarrierStatus": setCarrierStatus,
+	"expirePolicies": expirePolicies,
+	"renewPolicy": renewPolicy,
+	"confirmTerms": confirmTerms,
+	"cancelPolicy": cancelPolicy,
+	"endorsePolicy": endorsePolicy,
+	"consentToModification": consentToModification,
+	"withdrawModification": withdrawModification,
+	"fileClaim": fileClaim,
+	"adjudicateClaim": adjudicateClaim,
+	"recordPayout": recordPayout,
+	"getIncompletePolicies": func(stub LedgerStub, args []string) ([]byte, error) {
+		return getPolicies(stub, incompleteStage)
+	},
+	"getPendingPolicies": func(stub LedgerStub, args []string) ([]byte, error) {
+		return getPolicies(stub, pendingStage)
+	},
+	"getActivePolicies": func(stub LedgerStub, args []string) ([]byte, error) {
+		return getPolicies(stub, activeStage)
+	},
+	"listPolicies": listPolicies,
+	"getExpiringPolicies": getExpiringPolicies,
+	"getPolicyChain": getPolicyChain,
+	"getPolicy": getPolicy,
+	"getOpenQuoteRequests": getOpenQuoteRequests,
+	"getPendingVotes": getPendingVotes,
+	"getHolder": getHolder,
+	"getPoliciesByHolder": getPoliciesByHolder,
+	"getCarriers": func(stub LedgerStub, args []string) ([]byte, error) {
+		return getCarriers(stub)
+	},
+	"getCarriersByCountry": getCarriersByCountry,
+	"getPolicyHistory": getPolicyHistory,
+	"getPolicyVersion": getPolicyVersion,
+	"diffPolicyVersions": diffPolicyVersions,
+	"getClaim": getClaim,
+	"getClaimsByPolicy": getClaimsByPolicy,
+	"getStateMachine": getStateMachine,
+	"getRequestSchema": getRequestSchema,
+}
 
-	err = stub.PutState(pendingPoliciesString, pendingAsBytes)
+func initLedger(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: initLedger")
+
+	_, err := authorize(stub, "init")
 	if err != nil {
-		fmt.Println("Failed to initialize pending policies")
 		return nil, err
 	}
 
-	err = stub.PutState(incompletePoliciesString, incompleteAsBytes)
+	// Every record lives under its own key, so the only key Init creates is
+	// the schema version. Existing state is migrated, never reset, which makes
+	// Init safe to run on upgrade or to call again through Invoke.
+	err = migrateSchema(stub)
 	if err != nil {
-		fmt.Println("Failed to initialize incomplete policies")
+		fmt.Println("Failed to migrate ledger schema")
 		return nil, err
 	}
 
@@ -93,41 +147,34 @@ func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args [
 	return nil, nil
 }
 
-func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
-	fmt.Println("Method: SimpleChaincode.Invoke; received: " + function)
-
-	if function == "init" {
-		return t.Init(stub, "init", args)
-	} else if function == "generatePolicy" {
-		return generatePolicy(stub, args)
-	} else if function == "assignTerms" {
-		return assignTerms(stub, args)
-	} else if function == "castVote" {
-		return castVote(stub, args)
-	} else if function == "modifyPolicy" {
-		return modifyActivePolicy(stub, args)
+func invokeLedger(stub LedgerStub, function string, args []string) ([]byte, error) {
+	fmt.Println("Function: invokeLedger; received: " + function)
+
+	_, err := authorize(stub, function)
+	if err != nil {
+		return nil, err
 	}
-	
-	fmt.Println("Invoke did not find a function: " + function)
-	return nil, errors.New("Received unknown function invocation")
-}
 
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
-	fmt.Println("Method: SimpleChaincode.Query; received: " + function)
+	handler, found := chaincodeFunctions[function]
+	if !found {
+		fmt.Println("Invoke did not find a function: " + function)
+		return nil, newError(codeUnknownFunction, "Received unknown function invocation").with("function", function)
+	}
 
-	if function == "getPendingPolicies" {
-		return getPolicies(stub, pendingPoliciesString)
-	} else if function == "getIncompletePolicies" {
-		return getPolicies(stub, incompletePoliciesString)
-	} else if function == "getActivePolicies" {
-		return getPolicies(stub, activePoliciesString)
+	result, err := handler(stub, args)
+	if err != nil {
+		discardEvents(stub)
+		return nil, err
 	}
 
-	fmt.Println("Query did not find a function: " + function)
-	return nil, errors.New("Received unknown function query")
+	err = flushEvents(stub)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func write(stub *shim.ChaincodeStub, name string, value []byte) error {
+func write(stub LedgerStub, name string, value []byte) error {
 	fmt.Println("Function: write")
 	
 	err := stub.PutState(name, value)
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// The schema version of the ledger is stored under _schemaVersion. A ledger
+// without the key predates versioning and is treated as version 0.
+var schemaVersionString = "_schemaVersion"
+
+type migration struct {
+	Version int
+	Description string
+	Apply func(stub LedgerStub) error
+}
+
+// migrations bring the ledger from one schema version to the next and are
+// applied in order, all in the transaction of one Init. Fabric reads see only
+// committed state, so each step runs against a migrationLedger that shows it
+// what the earlier steps wrote. Each step must be idempotent: it may find its
+// work partially or entirely done, and must then leave the ledger unchanged.
+var migrations = []migration{
+	{1, "move legacy policy catalogs to per-policy keys", migrateCatalogs},
+	{2, "index open quote requests and pending votes", migrateWorkQueues},
+	{3, "record the status of every policy", migratePolicyStatus},
+	{4, "store the first version of every policy", migratePolicyVersions},
+	{5, "index the policies of every holder", migrateHolderPolicies},
+}
+
+func currentSchemaVersion() int {
+	return migrations[len(migrations) - 1].Version
+}
+
+func readSchemaVersion(stub LedgerStub) (int, error) {
+	fmt.Println("Function: readSchemaVersion")
+
+	versionAsBytes, err := stub.GetState(schemaVersionString)
+	if err != nil {
+		return 0, err
+	}
+	if versionAsBytes == nil {
+		return 0, nil
+	}
+
+	return strconv.Atoi(string(versionAsBytes))
+}
+
+// migrationLedger passes the writes of a transaction on to the stub and
+// answers later reads in the same transaction from them.
+type migrationLedger struct {
+	LedgerStub
+	// A nil value marks a deleted key
+	writes map[string][]byte
+}
+
+func (l *migrationLedger) GetState(key string) ([]byte, error) {
+	value, found := l.writes[key]
+	if found {
+		return value, nil
+	}
+	return l.LedgerStub.GetState(key)
+}
+
+func (l *migrationLedger) PutState(key string, value []byte) error {
+	err := l.LedgerStub.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	l.writes[key] = value
+	return nil
+}
+
+func (l *migrationLedger) DelState(key string) error {
+	err := l.LedgerStub.DelState(key)
+	if err != nil {
+		return err
+	}
+	l.writes[key] = nil
+	return nil
+}
+
+func (l *migrationLedger) RangeQueryState(startKey string, endKey string) (StateIterator, error) {
+	iter, err := l.LedgerStub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	values := make(map[string][]byte)
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	for key, value := range l.writes {
+		if key >= startKey && key < endKey {
+			values[key] = value
+		}
+	}
+
+	result := new(bufferedIterator)
+	for key, value := range values {
+		if value != nil {
+			result.keys = append(result.keys, key)
+		}
+	}
+	sort.Strings(result.keys)
+	i := 0
+	for i < len(result.keys) {
+		result.values = append(result.values, values[result.keys[i]])
+		i = i + 1
+	}
+	return result, nil
+}
+
+type bufferedIterator struct {
+	keys []string
+	values [][]byte
+	next int
+}
+
+func (i *bufferedIterator) HasNext() bool {
+	return i.next < len(i.keys)
+}
+
+func (i *bufferedIterator) Next() (string, []byte, error) {
+	if !i.HasNext() {
+		return "", nil, newError(codeInternal, "iterator exhausted")
+	}
+	i.next = i.next + 1
+	return i.keys[i.next - 1], i.values[i.next - 1], nil
+}
+
+func (i *bufferedIterator) Close() error {
+	return nil
+}
+
+// migrateSchema applies every migration newer than the ledger's schema
+// version and records the version reached. Existing state is never reset.
+func migrateSchema(stub LedgerStub) error {
+	fmt.Println("Function: migrateSchema")
+
+	stub = &migrationLedger{stub, make(map[string][]byte)}
+
+	version, err := readSchemaVersion(stub)
+	if err != nil {
+		return err
+	}
+	if version > currentSchemaVersion() {
+		return newError(codeInternal, "Ledger schema version " + strconv.Itoa(version) + " is newer than chaincode schema version " + strconv.Itoa(currentSchemaVersion()))
+	}
+
+	i := 0
+	for i < len(migrations) {
+		if migrations[i].Version > version {
+			fmt.Println("applying migration " + strconv.Itoa(migrations[i].Version) + ": " + migrations[i].Description)
+			err = migrations[i].Apply(stub)
+			if err != nil {
+				return err
+			}
+			version = migrations[i].Version
+
+			err = write(stub, schemaVersionString, []byte(strconv.Itoa(version)))
+			if err != nil {
+				return err
+			}
+		}
+		i = i + 1
+	}
+
+	fmt.Println("ledger is at schema version " + strconv.Itoa(version))
+	return nil
+}
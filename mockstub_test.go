@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"sort"
+)
+
+// memoryLedger is an in-memory LedgerStub. Each call to invoke or query is
+// one transaction with its own ID and timestamp. As on a Fabric peer, reads
+// see only committed state, and the writes of a transaction are committed
+// when it succeeds and discarded when it fails.
+type memoryLedger struct {
+	state map[string][]byte
+	writes map[string][]byte
+	txCount int
+	txTime int64
+	attributes map[string]string
+	events map[string]memoryEvent
+}
+
+type memoryEvent struct {
+	name string
+	payload []byte
+}
+
+func newMemoryLedger() *memoryLedger {
+	ledger := new(memoryLedger)
+	ledger.state = make(map[string][]byte)
+	ledger.attributes = make(map[string]string)
+	ledger.events = make(map[string]memoryEvent)
+	ledger.txTime = 1483228800 // 2017-01-01
+	return ledger
+}
+
+// as sets the certificate attributes presented by subsequent transactions.
+func (l *memoryLedger) as(role string, orgID string) *memoryLedger {
+	l.attributes[roleAttribute] = role
+	l.attributes[orgAttribute] = orgID
+	return l
+}
+
+func (l *memoryLedger) transact(run func() ([]byte, error)) ([]byte, error) {
+	l.txCount = l.txCount + 1
+	l.txTime = l.txTime + 60
+
+	// A nil value in writes marks a deleted key
+	l.writes = make(map[string][]byte)
+	result, err := run()
+	if err == nil {
+		for key, value := range l.writes {
+			if value == nil {
+				delete(l.state, key)
+			} else {
+				l.state[key] = value
+			}
+		}
+	}
+	l.writes = nil
+	return result, err
+}
+
+func (l *memoryLedger) init() ([]byte, error) {
+	return l.transact(func() ([]byte, error) {
+		return initLedger(l, []string{})
+	})
+}
+
+func (l *memoryLedger) invoke(function string, args ...string) ([]byte, error) {
+	return l.transact(func() ([]byte, error) {
+		return invokeLedger(l, function, args)
+	})
+}
+
+// query runs a function the same way as invoke; it is kept separate so that
+// tests read as the client would call the chaincode.
+func (l *memoryLedger) query(function string, args ...string) ([]byte, error) {
+	return l.invoke(function, args...)
+}
+
+func (l *memoryLedger) GetState(key string) ([]byte, error) {
+	return l.state[key], nil
+}
+
+func (l *memoryLedger) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key must not be empty")
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	l.writes[key] = value
+	return nil
+}
+
+func (l *memoryLedger) DelState(key string) error {
+	l.writes[key] = nil
+	return nil
+}
+
+func (l *memoryLedger) RangeQueryState(startKey string, endKey string) (StateIterator, error) {
+	keys := make([]string, 0)
+	for key := range l.state {
+		if key >= startKey && key < endKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	iter := new(memoryIterator)
+	for _, key := range keys {
+		iter.keys = append(iter.keys, key)
+		iter.values = append(iter.values, l.state[key])
+	}
+	return iter, nil
+}
+
+func (l *memoryLedger) GetTxID() string {
+	return fmt.Sprintf("tx%d", l.txCount)
+}
+
+func (l *memoryLedger) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: l.txTime}, nil
+}
+
+func (l *memoryLedger) SetEvent(name string, payload []byte) error {
+	l.events[l.GetTxID()] = memoryEvent{name, payload}
+	return nil
+}
+
+func (l *memoryLedger) ReadCertAttribute(attributeName string) ([]byte, error) {
+	value, found := l.attributes[attributeName]
+	if !found {
+		return nil, errors.New("attribute not found: " + attributeName)
+	}
+	return []byte(value), nil
+}
+
+type memoryIterator struct {
+	keys []string
+	values [][]byte
+	next int
+}
+
+func (i *memoryIterator) HasNext() bool {
+	return i.next < len(i.keys)
+}
+
+func (i *memoryIterator) Next() (string, []byte, error) {
+	if !i.HasNext() {
+		return "", nil, errors.New("iterator exhausted")
+	}
+	i.next = i.next + 1
+	return i.keys[i.next - 1], i.values[i.next - 1], nil
+}
+
+func (i *memoryIterator) Close() error {
+	return nil
+}
@@ -1,149 +1,129 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	"strconv"
 )
 
-func addPendingPolicy(stub *shim.ChaincodeStub, policy Policy) error {
-	fmt.Println("Function: addPendingPolicy")
-	
-	pendingAsBytes, err := getPolicies(stub, pendingPoliciesString)
-	if err != nil {
-		return err
-	}
-	fmt.Println("pending policies retrieved")
+func castVote(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: castVote")
 
-	var pendingPolicies AllPolicies
-	pendingPolicies, err = bytesToAllPolicies(pendingAsBytes)
+	request, err := parseVoteRequest(args)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Println("pending policies derived from bytes")
 
-	votes := make([]Approval, len(policy.Terms))
-	policy.Votes = votes
-	
-	pendingPolicies.Catalog = append(pendingPolicies.Catalog, policy)
-	fmt.Println("policy appended to pending policies")
-	
-	pendingAsBytes, err = json.Marshal(pendingPolicies)
-	err = write(stub, pendingPoliciesString, pendingAsBytes)
+	policyID := request.PolicyID
+	carrierID := request.CarrierID
+	voteCast := request.Vote
+
+	err = checkActingAs(stub, carrierRole, carrierID)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Println("pending policies successfully rewritten with complete policy")
-	return nil
-}
-
-func castVote(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-	fmt.Println("Function: castVote")
 
-	if len(args) != 3 {
-		return nil, errors.New("Expected three arguments; arguments received: " + strconv.Itoa(len(args)))
+	// Carriers vote on pending policies, and on the modifications of active
+	// ones
+	stage, err := findPolicyStage(stub, policyID)
+	if err != nil {
+		return nil, err
 	}
-	
-	policyID := args[0]
-	carrierID := args[1]
-	voteCast := args[2]
-
-	if voteCast != "approve" && voteCast != "disapprove" {
-		return nil, errors.New("Invalid vote: " + voteCast)
+	if stage != activeStage {
+		stage = pendingStage
 	}
-	
-	pendingPolicies, err := readPolicies(stub, pendingPoliciesString)
+	policy, err := getPolicyByHash(stub, stage, policyID)
 	if err != nil {
 		return nil, err
 	}
-	
-	var policyIndex int
-	policyIndex, err = getPolicyByHash(pendingPolicies.Catalog, policyID)
+
+	err = checkExpectedVersion(policy, request.ExpectedVersion)
 	if err != nil {
 		return nil, err
 	}
-	
-	i := 0
-	for i < len(pendingPolicies.Catalog[policyIndex].Terms) {
-		if pendingPolicies.Catalog[policyIndex].Terms[i].CarrierID == carrierID {
-			err = vote(&pendingPolicies.Catalog[policyIndex], i, carrierID, voteCast)
+
+	previousTerms := append([]CarrierTerms{}, policy.Terms...)
+	votes := policy.Votes
+	if stage == activeStage {
+		if policy.Modification == nil {
+			return nil, newError(codeWrongStage, "Policy " + policy.ID + " has no modification pending").with("policyID", policy.ID).with("stage", activeStage)
 		}
-		i = i + 1
+		votes = policy.Modification.Votes
 	}
 
-	err = checkActive(&pendingPolicies.Catalog[policyIndex])
+	err = checkNotLapsed(stub, policy)
 	if err != nil {
-		err = writePolicies(stub, pendingPoliciesString, pendingPolicies)
-		if err != nil {
-			return nil, err
-		}
-		fmt.Println("pending policies successfully written with new vote(s)")
-		return nil, nil
+		return nil, err
+	}
+
+	timestamp, err := txTimestamp(stub)
+	if err != nil {
+		return nil, err
 	}
 
-	i = 0
-	for i < len(pendingPolicies.Catalog[policyIndex].Votes) {
-		if pendingPolicies.Catalog[policyIndex].Votes[i].Vote != "approve" {
-			incompletePolicy := removePolicy(&pendingPolicies, policyIndex)
-
-			policyArgs := make([]string, len(incompletePolicy.Countries) + 1)
-			policyArgs[0] = incompletePolicy.HolderID
-			j := 0
-			for j < len(incompletePolicy.Countries) {
-				policyArgs[j + 1] = incompletePolicy.Countries[j]
-				j = j + 1
+	votedCountries := make([]string, 0)
+	carrierFound := false
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].CarrierID == carrierID {
+			carrierFound = true
+
+			// Approvals kept after a rejection, and of the terms an
+			// endorsement leaves unchanged, still stand
+			if votes[i].Vote != "" {
+				i = i + 1
+				continue
 			}
-		
-			_, err = generatePolicy(stub, policyArgs)
+
+			err = checkCarrierLicensed(stub, carrierID, policy.Terms[i].Country)
 			if err != nil {
 				return nil, err
 			}
-			err = writePolicies(stub, pendingPoliciesString, pendingPolicies)
+			err = vote(policy.ID, votes, i, carrierID, voteCast)
 			if err != nil {
 				return nil, err
 			}
-			fmt.Println("pending policies successfully written after removal of incomplete policy")
-			return nil, nil
+			votedCountries = append(votedCountries, policy.Terms[i].Country)
+
+			if voteCast == "disapprove" {
+				var rejection Rejection
+				rejection.CarrierID = carrierID
+				rejection.Country = policy.Terms[i].Country
+				rejection.TermsID = policy.Terms[i].ID
+				if stage == activeStage {
+					rejection.Country = policy.Modification.Terms.Country
+					rejection.TermsID = policy.Modification.Terms.ID
+				}
+				rejection.Reason = request.Reason
+				rejection.TxID = stub.GetTxID()
+				rejection.Timestamp = timestamp
+				policy.Rejections = append(policy.Rejections, rejection)
+			}
 		}
 		i = i + 1
 	}
-	activePolicy := removePolicy(&pendingPolicies, policyIndex)
-	err = addActivePolicy(stub, activePolicy)
-	if err != nil {
-		return nil, err
+	if !carrierFound {
+		return nil, newError(codeInvalidArgument, "Carrier " + carrierID + " has no terms on policy " + policy.ID).with("policyID", policy.ID).with("carrier", carrierID)
+	}
+	if len(votedCountries) == 0 {
+		return nil, newError(codeDuplicateVote, "vote has already been cast").with("policyID", policy.ID).with("carrier", carrierID)
 	}
-	err = writePolicies(stub, pendingPoliciesString, pendingPolicies)
+	queueEvent(stub, voteCastEvent, stage, policy, []string{carrierID}, votedCountries)
+
+	_, err = applyTransition(stub, "castVote", previousTerms, policy)
 	if err != nil {
 		return nil, err
 	}
-	
-	fmt.Println("pending policies successfully written after moving policy to active policies")	
+	fmt.Println(stage + " policy successfully written with new vote(s)")
 	return nil, nil
 }
 
-func vote(policy *Policy, index int, carrierID string, vote string) error {
+func vote(policyID string, votes []Approval, index int, carrierID string, vote string) error {
 	fmt.Println("Function: vote")
 	
-	if policy.Votes[index].Vote != "" {
-		return errors.New("vote has already been cast")
+	if votes[index].Vote != "" {
+		return newError(codeDuplicateVote, "vote has already been cast").with("policyID", policyID).with("carrier", carrierID)
 	}
 
-	policy.Votes[index].CarrierID = carrierID
-	policy.Votes[index].Vote = vote
-	return nil
-}
-
-func checkActive(policy *Policy) error {
-	fmt.Println("Function: checkActive")
-	
-	i := 0
-	for i < len(policy.Votes) {
-		if policy.Votes[i].CarrierID == "" {
-			return errors.New("Not all votes have been cast")
-		}
-		i = i + 1
-	}	
+	votes[index].CarrierID = carrierID
+	votes[index].Vote = vote
 	return nil
 }
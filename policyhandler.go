@@ -2,93 +2,567 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"strconv"
+	"strings"
 )
 
-func getPolicies(stub *shim.ChaincodeStub, policiesString string) ([]byte, error) {
-	fmt.Println("Function: getPolicies (" + policiesString + ")")
-	
-	policiesAsBytes, err := stub.GetState(policiesString)
+// Each policy is stored under its own key, policy~<stage>~<id>, so that the
+// key prefix of a stage doubles as the index of every policy in that stage.
+var policyKeyPrefix = "policy~"
+
+// Every CarrierTerms.ID ever issued is reserved under terms~<id>, holding the
+// ID of the policy the terms were written for.
+var termsKeyPrefix = "terms~"
+
+var incompleteStage = "incomplete"
+var pendingStage = "pending"
+var activeStage = "active"
+var expiredStage = "expired"
+var cancelledStage = "cancelled"
+
+var policyStages = []string{incompleteStage, pendingStage, activeStage, expiredStage, cancelledStage}
+
+var defaultListPageSize = 50
+
+func policyKey(stage string, id string) string {
+	return policyKeyPrefix + stage + "~" + id
+}
+
+// prefixRange returns the range of keys beginning with prefix, which must end
+// in the "~" separator; "\x7f" sorts directly after "~".
+func prefixRange(prefix string) (string, string) {
+	return prefix, prefix[:len(prefix) - 1] + "\x7f"
+}
+
+// checkKeyPart rejects an ID or country containing "~", which would let the
+// keys built from it fall within the prefix range of another.
+func checkKeyPart(field string, value string) error {
+	if strings.Contains(value, "~") {
+		return newError(codeInvalidArgument, field + " must not contain \"~\": " + value).with("field", field)
+	}
+	return nil
+}
+
+func getPolicies(stub LedgerStub, stage string) ([]byte, error) {
+	fmt.Println("Function: getPolicies (" + stage + ")")
+
+	policies, err := readPolicies(stub, stage)
 	if err != nil {
-		jsonResp := "{\"Error\": \"Failed to get policies.\"}"
-		return nil, errors.New(jsonResp)
+		return nil, newError(codeInternal, "Failed to get policies: " + err.Error()).with("stage", stage)
 	}
 
-	return policiesAsBytes, nil
+	return json.Marshal(policies)
 }
 
-func removePolicy(policies *AllPolicies, index int) Policy {
-	fmt.Println("Function: removePolicy")
-	var pendingPolicy Policy
-	pendingPolicy = policies.Catalog[index]
+// listPolicies returns one page of the policies in a stage that match the
+// filters of a ListRequest, in ID order. The bookmark of the page is the ID
+// of its last policy and is empty on the last page. A page is read from just
+// after the bookmark and stops at the first match past it; the total number
+// of matches is only counted on request, as that reads the whole stage.
+func listPolicies(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: listPolicies")
+
+	request, err := parseListRequest(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, request.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var page PolicyPage
+	page.Policies = make([]Policy, 0)
+
+	startKey, endKey := prefixRange(policyKey(request.Stage, ""))
+	if request.Bookmark != "" && !request.IncludeTotal {
+		startKey = policyKey(request.Stage, request.Bookmark) + "\x00"
+	}
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	total := 0
+	more := false
+	for iter.HasNext() {
+		_, policyAsBytes, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
-	copy(policies.Catalog[:index], policies.Catalog[index + 1:])
-	policies.Catalog = policies.Catalog[:len(policies.Catalog) - 1]
-	
-	return pendingPolicy
+		var policy Policy
+		err = json.Unmarshal(policyAsBytes, &policy)
+		if err != nil {
+			return nil, err
+		}
+		if !matchesListRequest(policy, request) {
+			continue
+		}
+
+		total = total + 1
+		if policy.ID <= request.Bookmark {
+			continue
+		}
+		if len(page.Policies) < request.PageSize {
+			page.Policies = append(page.Policies, policy)
+		} else {
+			more = true
+			if !request.IncludeTotal {
+				break
+			}
+		}
+	}
+
+	if more {
+		page.Bookmark = page.Policies[len(page.Policies) - 1].ID
+	}
+	if request.IncludeTotal {
+		page.Total = &total
+	}
+	return json.Marshal(page)
+}
+
+func matchesListRequest(policy Policy, request ListRequest) bool {
+	if request.HolderID != "" && policy.HolderID != request.HolderID {
+		return false
+	}
+
+	carrierFound := request.CarrierID == ""
+	var premium int64
+	var value int64
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].CarrierID == request.CarrierID {
+			carrierFound = true
+		}
+		premium = premium + policy.Terms[i].Premium
+		value = value + policy.Terms[i].Value
+		i = i + 1
+	}
+	if !carrierFound {
+		return false
+	}
+
+	countryFound := request.Country == ""
+	i = 0
+	for i < len(policy.Countries) {
+		if policy.Countries[i] == request.Country {
+			countryFound = true
+		}
+		i = i + 1
+	}
+	if !countryFound {
+		return false
+	}
+
+	if request.MinPremium != nil && premium < *request.MinPremium {
+		return false
+	}
+	if request.MaxPremium != nil && premium > *request.MaxPremium {
+		return false
+	}
+	if request.MinValue != nil && value < *request.MinValue {
+		return false
+	}
+	if request.MaxValue != nil && value > *request.MaxValue {
+		return false
+	}
+	return true
 }
 
 func bytesToAllPolicies(policiesAsBytes []byte) (AllPolicies, error) {
 	fmt.Println("Function: bytesToAllPolicies")
-	
+
 	var policies AllPolicies
 
 	err := json.Unmarshal(policiesAsBytes, &policies)
 	fmt.Println("json.Unmarshal error:")
 	fmt.Println(err)
-	
+
 	return policies, err
 }
 
-func readPolicies(stub *shim.ChaincodeStub, policiesString string) (AllPolicies, error){
-	fmt.Println("Function: readPolicies")
-	
+func readPolicies(stub LedgerStub, stage string) (AllPolicies, error) {
+	fmt.Println("Function: readPolicies (" + stage + ")")
+
 	var policies AllPolicies
-	policiesAsBytes, err := getPolicies(stub, policiesString)
+	policies.Catalog = make([]Policy, 0)
+
+	startKey, endKey := prefixRange(policyKey(stage, ""))
+	iter, err := stub.RangeQueryState(startKey, endKey)
 	if err != nil {
 		return policies, err
 	}
-	fmt.Println("policies retrieved as bytes")
-	
-	policies, err = bytesToAllPolicies(policiesAsBytes)
-	if err != nil {
-		return policies, err
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, policyAsBytes, err := iter.Next()
+		if err != nil {
+			return policies, err
+		}
+
+		var policy Policy
+		err = json.Unmarshal(policyAsBytes, &policy)
+		if err != nil {
+			return policies, err
+		}
+		policies.Catalog = append(policies.Catalog, policy)
 	}
-	fmt.Println("policies retrieved from bytes")
-	
+	fmt.Println("policies retrieved from " + stage + " stage")
+
 	return policies, nil
 }
 
-func writePolicies(stub *shim.ChaincodeStub, policiesString string, policies AllPolicies) error {
-	fmt.Println("Function: writePolicies")
-	
-	policiesAsBytes, err := json.Marshal(policies)
+func writePolicy(stub LedgerStub, stage string, policy Policy) error {
+	fmt.Println("Function: writePolicy (" + stage + ")")
+
+	oldPolicy, err := readStoredPolicy(stub, stage, policy.ID)
+	if err != nil {
+		return err
+	}
+
+	policyAsBytes, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	err = write(stub, policyKey(stage, policy.ID), policyAsBytes)
+	if err != nil {
+		return err
+	}
+
+	err = updatePolicyIndexes(stub, stage, oldPolicy, &policy)
+	if err != nil {
+		return err
+	}
+	fmt.Println("policy " + policy.ID + " written")
+	return nil
+}
+
+// policyIndexKeys returns the keys under which a policy held in stage is
+// indexed.
+func policyIndexKeys(stage string, policy Policy) []string {
+	keys := append(workQueueKeys(stage, policy), expiryKeys(stage, policy)...)
+	return append(keys, cancellationKeys(stage, policy)...)
+}
+
+// updatePolicyIndexes replaces the index keys of the stored record of a
+// policy with those of its new record. Either record may be nil.
+func updatePolicyIndexes(stub LedgerStub, stage string, oldPolicy *Policy, newPolicy *Policy) error {
+	fmt.Println("Function: updatePolicyIndexes (" + stage + ")")
+
+	newKeys := make(map[string]bool)
+	if newPolicy != nil {
+		keys := policyIndexKeys(stage, *newPolicy)
+		i := 0
+		for i < len(keys) {
+			newKeys[keys[i]] = true
+			i = i + 1
+		}
+	}
+
+	if oldPolicy != nil {
+		keys := policyIndexKeys(stage, *oldPolicy)
+		i := 0
+		for i < len(keys) {
+			if !newKeys[keys[i]] {
+				err := stub.DelState(keys[i])
+				if err != nil {
+					return err
+				}
+			}
+			i = i + 1
+		}
+	}
+
+	for key := range newKeys {
+		err := write(stub, key, []byte(newPolicy.ID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStoredPolicy returns the record of a policy in stage, or nil when there
+// is none.
+func readStoredPolicy(stub LedgerStub, stage string, id string) (*Policy, error) {
+	policyAsBytes, err := stub.GetState(policyKey(stage, id))
+	if err != nil {
+		return nil, err
+	}
+	if policyAsBytes == nil {
+		return nil, nil
+	}
+
+	var policy Policy
+	err = json.Unmarshal(policyAsBytes, &policy)
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func deletePolicy(stub LedgerStub, stage string, id string) error {
+	fmt.Println("Function: deletePolicy (" + stage + ")")
+
+	oldPolicy, err := readStoredPolicy(stub, stage, id)
 	if err != nil {
 		return err
 	}
-	fmt.Println("policies converted to bytes")
-	
-	err = write(stub, policiesString, policiesAsBytes)
+
+	err = stub.DelState(policyKey(stage, id))
 	if err != nil {
 		return err
 	}
-	fmt.Println("policies written")
+
+	err = updatePolicyIndexes(stub, stage, oldPolicy, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Println("policy " + id + " deleted")
 	return nil
 }
 
-func getPolicyByHash(policies []Policy, hash string) (int, error) {
+func getPolicyByHash(stub LedgerStub, stage string, hash string) (Policy, error) {
 	fmt.Println("Function: getPolicyByHash")
-	
-	var i int
-	i = 0
-	for i < len(policies) {
-		if policies[i].ID == hash {
-			return i, nil
+
+	var policy Policy
+	policyAsBytes, err := stub.GetState(policyKey(stage, hash))
+	if err != nil {
+		return policy, err
+	}
+	if policyAsBytes == nil {
+		found, err := findPolicyStage(stub, hash)
+		if err != nil {
+			return policy, err
+		}
+		if found != "" {
+			return policy, newError(codeWrongStage, "Policy " + hash + " is " + found + ", not " + stage).with("policyID", hash).with("stage", found).with("expected", stage)
+		}
+		return policy, newError(codePolicyNotFound, "No policy found with hash: " + hash).with("policyID", hash)
+	}
+
+	err = json.Unmarshal(policyAsBytes, &policy)
+	return policy, err
+}
+
+// readPolicyInStages returns the record of a policy in the first of stages
+// that holds one. An endorsed copy in another stage is not returned.
+func readPolicyInStages(stub LedgerStub, stages []string, policyID string) (Policy, error) {
+	fmt.Println("Function: readPolicyInStages")
+
+	i := 0
+	for i < len(stages) {
+		policy, err := readStoredPolicy(stub, stages[i], policyID)
+		if err != nil {
+			return Policy{}, err
+		}
+		if policy != nil {
+			return *policy, nil
 		}
 		i = i + 1
 	}
-	
-	return 0, errors.New("No policy found with hash: " + hash)
+	return getPolicyByHash(stub, stages[0], policyID)
+}
+
+// getPolicy returns one policy, looked up across every stage, with what it is
+// still waiting on. A policy whose endorsement is under review is reported in
+// the stage of the endorsed version.
+func getPolicy(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getPolicy")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	stage, err := findPolicyStage(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if stage == "" {
+		return nil, newError(codePolicyNotFound, "No policy found with hash: " + args[0]).with("policyID", args[0])
+	}
+
+	policy, err := getPolicyByHash(stub, stage, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, policy.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var detail PolicyDetail
+	detail.Policy = policy
+	detail.Stage = stage
+	detail.OutstandingCountries = make([]string, 0)
+	detail.OutstandingVoters = make([]string, 0)
+
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].ID == "" {
+			detail.OutstandingCountries = append(detail.OutstandingCountries, policy.Terms[i].Country)
+		}
+		i = i + 1
+	}
+
+	votes := policy.Votes
+	if policy.Modification != nil {
+		votes = policy.Modification.Votes
+	}
+	if stage == pendingStage || policy.Modification != nil {
+		// Approvals of terms kept after a rejection stand, so a carrier may
+		// have voted on some of its terms and not others
+		outstanding := make(map[string]bool)
+		i = 0
+		for i < len(votes) {
+			if votes[i].Vote == "" {
+				outstanding[policy.Terms[i].CarrierID] = true
+			}
+			i = i + 1
+		}
+		carriers := policyCarriers(policy)
+		i = 0
+		for i < len(carriers) {
+			if outstanding[carriers[i]] {
+				detail.OutstandingVoters = append(detail.OutstandingVoters, carriers[i])
+			}
+			i = i + 1
+		}
+	}
+	if policy.Modification != nil && policy.Modification.HolderVote == "" {
+		detail.OutstandingVoters = append(detail.OutstandingVoters, policy.HolderID)
+	}
+
+	return json.Marshal(detail)
+}
+
+// findPolicyStage returns the stage holding the policy with the given ID, or
+// "" when no stage does.
+func findPolicyStage(stub LedgerStub, id string) (string, error) {
+	fmt.Println("Function: findPolicyStage")
+
+	i := 0
+	for i < len(policyStages) {
+		policyAsBytes, err := stub.GetState(policyKey(policyStages[i], id))
+		if err != nil {
+			return "", err
+		}
+		if policyAsBytes != nil {
+			return policyStages[i], nil
+		}
+		i = i + 1
+	}
+	return "", nil
+}
+
+// readAnyPolicy returns a policy from whichever stage holds it.
+func readAnyPolicy(stub LedgerStub, policyID string) (Policy, error) {
+	fmt.Println("Function: readAnyPolicy")
+
+	var policy Policy
+	stage, err := findPolicyStage(stub, policyID)
+	if err != nil {
+		return policy, err
+	}
+	if stage == "" {
+		return policy, newError(codePolicyNotFound, "No policy found with hash: " + policyID).with("policyID", policyID)
+	}
+	return getPolicyByHash(stub, stage, policyID)
+}
+
+// checkPolicyIDUnused rejects an ID already held by a policy in any stage.
+func checkPolicyIDUnused(stub LedgerStub, id string) error {
+	fmt.Println("Function: checkPolicyIDUnused")
+
+	found, err := findPolicyStage(stub, id)
+	if err != nil {
+		return err
+	}
+	if found != "" {
+		return newError(codeAlreadyExists, "Policy already exists with hash: " + id).with("policyID", id).with("stage", found)
+	}
+	return nil
+}
+
+// reserveTermsID records a newly issued terms ID, rejecting one that has
+// already been issued to any policy.
+func reserveTermsID(stub LedgerStub, termsID string, policyID string) error {
+	fmt.Println("Function: reserveTermsID")
+
+	policyAsBytes, err := stub.GetState(termsKeyPrefix + termsID)
+	if err != nil {
+		return err
+	}
+	if policyAsBytes != nil {
+		return newError(codeAlreadyExists, "Terms already exist with ID: " + termsID).with("termsID", termsID)
+	}
+
+	return write(stub, termsKeyPrefix + termsID, []byte(policyID))
+}
+
+// migrateCatalogs moves every policy out of the legacy single-key catalogs
+// into per-policy keys and then deletes the catalogs. Catalogs that are
+// already gone are skipped, so it is safe to run more than once. The legacy
+// IDs could collide, so a policy whose ID has already been migrated is given
+// a new one derived from its ID and its place in the catalogs.
+func migrateCatalogs(stub LedgerStub) error {
+	fmt.Println("Function: migrateCatalogs")
+
+	catalogs := []string{incompletePoliciesString, pendingPoliciesString, activePoliciesString}
+
+	i := 0
+	for i < len(catalogs) {
+		stage := policyStages[i]
+
+		catalogAsBytes, err := stub.GetState(catalogs[i])
+		if err != nil {
+			return err
+		}
+		if catalogAsBytes == nil {
+			i = i + 1
+			continue
+		}
+
+		var catalog AllPolicies
+		catalog, err = bytesToAllPolicies(catalogAsBytes)
+		if err != nil {
+			return err
+		}
+
+		j := 0
+		for j < len(catalog.Catalog) {
+			found, err := findPolicyStage(stub, catalog.Catalog[j].ID)
+			if err != nil {
+				return err
+			}
+			if found != "" {
+				id := makeHash(stub.GetTxID(), []string{catalog.Catalog[j].ID, catalogs[i], strconv.Itoa(j)})
+				fmt.Println("duplicate policy " + catalog.Catalog[j].ID + " in " + catalogs[i] + " migrated as " + id)
+				catalog.Catalog[j].ID = id
+			}
+
+			catalog.Catalog[j].Status = stage
+			err = writePolicy(stub, stage, catalog.Catalog[j])
+			if err != nil {
+				return err
+			}
+			j = j + 1
+		}
+
+		err = stub.DelState(catalogs[i])
+		if err != nil {
+			return err
+		}
+		fmt.Println(catalogs[i] + " migrated to " + stage + " stage")
+		i = i + 1
+	}
+
+	return nil
 }
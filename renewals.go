@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// A renewal is a new policy for the same holder and countries whose
+// PredecessorID names the policy it renews. Each policy may be renewed once;
+// its successor is recorded under policyRenewal~<predecessorID>.
+//
+// The terms of the predecessor are carried into the renewal as proposals:
+// the carrier, premium and value are filled in but the terms have no ID, so
+// the country still counts as unquoted. The carrier confirms them with
+// confirmTerms, or any licensed carrier quotes afresh with assignTerms.
+var policyRenewalKeyPrefix = "policyRenewal~"
+
+func policyRenewalKey(policyID string) string {
+	return policyRenewalKeyPrefix + policyID
+}
+
+func readSuccessorID(stub LedgerStub, policyID string) (string, error) {
+	successorAsBytes, err := stub.GetState(policyRenewalKey(policyID))
+	if err != nil {
+		return "", err
+	}
+	return string(successorAsBytes), nil
+}
+
+func renewPolicy(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: renewPolicy")
+
+	request, err := parseRenewalRequest(args)
+	if err != nil {
+		return nil, err
+	}
+
+	predecessor, err := readPolicyInStages(stub, []string{activeStage, expiredStage}, request.PolicyID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, predecessor.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	successorID, err := readSuccessorID(stub, predecessor.ID)
+	if err != nil {
+		return nil, err
+	}
+	if successorID != "" {
+		return nil, newError(codeAlreadyExists, "Policy " + predecessor.ID + " has already been renewed as " + successorID).with("policyID", predecessor.ID).with("successorID", successorID)
+	}
+
+	var policyRequest PolicyRequest
+	policyRequest.HolderID = predecessor.HolderID
+	policyRequest.Countries = predecessor.Countries
+	policyRequest.EffectiveDate = request.EffectiveDate
+	policyRequest.ExpiryDate = request.ExpiryDate
+
+	successor := createPolicyObject(stub.GetTxID(), policyRequest)
+	successor.PredecessorID = predecessor.ID
+
+	err = setRenewalPeriod(stub, predecessor, &successor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cancelled terms are left for any carrier to quote
+	i := 0
+	for i < len(predecessor.Terms) {
+		if predecessor.Terms[i].CancellationDate != "" {
+			i = i + 1
+			continue
+		}
+		successor.Terms[i].CarrierID = predecessor.Terms[i].CarrierID
+		successor.Terms[i].Premium = predecessor.Terms[i].Premium
+		successor.Terms[i].Value = predecessor.Terms[i].Value
+		i = i + 1
+	}
+
+	err = checkPolicyIDUnused(stub, successor.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = write(stub, policyRenewalKey(predecessor.ID), []byte(successor.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = addPolicyToHolder(stub, successor, successor.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = applyTransition(stub, "renewPolicy", nil, successor)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("policy " + predecessor.ID + " renewed as " + successor.ID)
+
+	return []byte(successor.ID), nil
+}
+
+// setRenewalPeriod defaults the period of a renewal to start the day after
+// its predecessor expires, or on the transaction date if that has passed,
+// and to last as long as the predecessor did.
+func setRenewalPeriod(stub LedgerStub, predecessor Policy, successor *Policy) error {
+	fmt.Println("Function: setRenewalPeriod")
+
+	today, err := txDate(stub)
+	if err != nil {
+		return err
+	}
+
+	if successor.EffectiveDate == "" && predecessor.ExpiryDate != "" {
+		expiry, _ := time.Parse(dateLayout, predecessor.ExpiryDate)
+		effective := expiry.AddDate(0, 0, 1)
+		if effective.Before(today) {
+			effective = today
+		}
+		successor.EffectiveDate = effective.Format(dateLayout)
+	}
+
+	if successor.EffectiveDate != "" && successor.ExpiryDate == "" && predecessor.EffectiveDate != "" {
+		previousEffective, _ := time.Parse(dateLayout, predecessor.EffectiveDate)
+		previousExpiry, _ := time.Parse(dateLayout, predecessor.ExpiryDate)
+		effective, _ := time.Parse(dateLayout, successor.EffectiveDate)
+		successor.ExpiryDate = effective.Add(previousExpiry.Sub(previousEffective)).Format(dateLayout)
+	}
+
+	return setPolicyPeriod(stub, successor)
+}
+
+// confirmTerms issues terms for every country of a renewal in which the
+// carrier's terms from the predecessor are still proposed, unchanged.
+func confirmTerms(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: confirmTerms")
+
+	request, err := parseConfirmRequest(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, carrierRole, request.CarrierID)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := getPolicyByHash(stub, incompleteStage, request.PolicyID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkExpectedVersion(policy, request.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkNotLapsed(stub, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	previousTerms := append([]CarrierTerms{}, policy.Terms...)
+	confirmedCountries := make([]string, 0)
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].CarrierID == request.CarrierID && policy.Terms[i].ID == "" {
+			err = checkCarrierLicensed(stub, request.CarrierID, policy.Terms[i].Country)
+			if err != nil {
+				return nil, err
+			}
+
+			var termsRequest TermsRequest
+			termsRequest.PolicyID = policy.ID
+			termsRequest.CarrierID = request.CarrierID
+			termsRequest.Country = policy.Terms[i].Country
+			termsRequest.Premium = &policy.Terms[i].Premium
+			termsRequest.Value = &policy.Terms[i].Value
+
+			terms := createTerms(stub.GetTxID(), termsRequest)
+			err = setTermsPeriod(stub, policy, &terms, nil)
+			if err != nil {
+				return nil, err
+			}
+			err = reserveTermsID(stub, terms.ID, policy.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			policy.Terms[i] = terms
+			confirmedCountries = append(confirmedCountries, terms.Country)
+		}
+		i = i + 1
+	}
+	if len(confirmedCountries) == 0 {
+		return nil, newError(codeInvalidArgument, "Carrier " + request.CarrierID + " has no proposed terms on policy " + policy.ID).with("policyID", policy.ID).with("carrier", request.CarrierID)
+	}
+	queueEvent(stub, termsAssignedEvent, incompleteStage, policy, []string{request.CarrierID}, confirmedCountries)
+
+	_, err = applyTransition(stub, "confirmTerms", previousTerms, policy)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("proposed terms confirmed on policy " + policy.ID)
+	return nil, nil
+}
+
+// getPolicyChain returns every renewal of the policy's program, from the
+// first policy to the latest renewal.
+func getPolicyChain(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getPolicyChain")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	policy, err := readAnyPolicy(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, policy.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := []Policy{policy}
+	for chain[0].PredecessorID != "" {
+		predecessor, err := readAnyPolicy(stub, chain[0].PredecessorID)
+		if err != nil {
+			return nil, err
+		}
+		chain = append([]Policy{predecessor}, chain...)
+	}
+
+	successorID, err := readSuccessorID(stub, policy.ID)
+	if err != nil {
+		return nil, err
+	}
+	for successorID != "" {
+		successor, err := readAnyPolicy(stub, successorID)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, successor)
+
+		successorID, err = readSuccessorID(stub, successor.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var policies AllPolicies
+	policies.Catalog = chain
+	return json.Marshal(policies)
+}
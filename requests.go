@@ -0,0 +1,774 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// generatePolicy, assignTerms, modifyPolicy and castVote each take a single
+// JSON request document, described by the schemas below and validated
+// strictly. The positional arguments they took before are still accepted but
+// deprecated; they are validated by the same rules.
+
+// The dates of a PolicyRequest or TermsRequest may be omitted; see
+// setPolicyPeriod and setTermsPeriod for their defaults.
+type PolicyRequest struct {
+	HolderID string `json:"holderID"`
+	Countries []string `json:"countries"`
+	EffectiveDate string `json:"effectiveDate"`
+	ExpiryDate string `json:"expiryDate"`
+}
+
+type TermsRequest struct {
+	PolicyID string `json:"policyID"`
+	CarrierID string `json:"carrier"`
+	Country string `json:"country"`
+	Premium *int64 `json:"premium"`
+	Value *int64 `json:"value"`
+	EffectiveDate string `json:"effectiveDate"`
+	ExpiryDate string `json:"expiryDate"`
+	ExpectedVersion int `json:"expectedVersion"`
+}
+
+// VoteRequest.Reason is required to disapprove.
+type VoteRequest struct {
+	PolicyID string `json:"policyID"`
+	CarrierID string `json:"carrier"`
+	Vote string `json:"vote"`
+	Reason string `json:"reason"`
+	ExpectedVersion int `json:"expectedVersion"`
+}
+
+// ConsentRequest is the holder's vote on a modification. Reason is required
+// to disapprove.
+type ConsentRequest struct {
+	PolicyID string `json:"policyID"`
+	HolderID string `json:"holderID"`
+	Vote string `json:"vote"`
+	Reason string `json:"reason"`
+	ExpectedVersion int `json:"expectedVersion"`
+}
+
+// WithdrawalRequest withdraws the modification pending on a policy.
+type WithdrawalRequest struct {
+	PolicyID string `json:"policyID"`
+	ExpectedVersion int `json:"expectedVersion"`
+}
+
+// RenewalRequest.EffectiveDate defaults to the day after the predecessor
+// expires, and ExpiryDate to the same length of cover as the predecessor.
+type RenewalRequest struct {
+	PolicyID string `json:"policyID"`
+	EffectiveDate string `json:"effectiveDate"`
+	ExpiryDate string `json:"expiryDate"`
+}
+
+type ConfirmRequest struct {
+	PolicyID string `json:"policyID"`
+	CarrierID string `json:"carrier"`
+	ExpectedVersion int `json:"expectedVersion"`
+}
+
+// CancelRequest cancels the whole policy, or only the terms for Country when
+// it is set.
+type CancelRequest struct {
+	PolicyID string `json:"policyID"`
+	ReasonCode string `json:"reasonCode"`
+	EffectiveDate string `json:"effectiveDate"`
+	Country string `json:"country"`
+	ExpectedVersion int `json:"expectedVersion"`
+}
+
+// EndorsementRequest adds Country to an active policy or removes it, as
+// Action is addCountry or removeCountry.
+type EndorsementRequest struct {
+	PolicyID string `json:"policyID"`
+	Action string `json:"action"`
+	Country string `json:"country"`
+	ExpectedVersion int `json:"expectedVersion"`
+}
+
+// ListRequest selects a page of the policies in one stage. Empty filters
+// match every policy; the premium and value ranges apply to the totals over
+// all of a policy's terms. IncludeTotal counts every match in the stage.
+type ListRequest struct {
+	Stage string `json:"stage"`
+	PageSize int `json:"pageSize"`
+	Bookmark string `json:"bookmark"`
+	IncludeTotal bool `json:"includeTotal"`
+	HolderID string `json:"holderID"`
+	CarrierID string `json:"carrier"`
+	Country string `json:"country"`
+	MinPremium *int64 `json:"minPremium"`
+	MaxPremium *int64 `json:"maxPremium"`
+	MinValue *int64 `json:"minValue"`
+	MaxValue *int64 `json:"maxValue"`
+}
+
+type FieldError struct {
+	Field string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field of a request that failed validation.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) add(field string, message string) {
+	e.Errors = append(e.Errors, FieldError{field, message})
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0)
+	i := 0
+	for i < len(e.Errors) {
+		messages = append(messages, e.Errors[i].Field + ": " + e.Errors[i].Message)
+		i = i + 1
+	}
+	return "Invalid request: " + strings.Join(messages, "; ")
+}
+
+// orNil returns nil when every field is valid, and otherwise an
+// INVALID_ARGUMENT error listing the failing fields in its details.
+func (e *ValidationError) orNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return newError(codeInvalidArgument, e.Error()).with("fields", e.Errors)
+}
+
+var termsSchema = `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["policyID", "carrier", "country", "premium", "value"],
+	"properties": {
+		"policyID": {"type": "string", "minLength": 1},
+		"carrier": {"type": "string", "minLength": 1},
+		"country": {"type": "string", "minLength": 1},
+		"premium": {"type": "integer", "minimum": 0},
+		"value": {"type": "integer", "minimum": 0},
+		"effectiveDate": {"type": "string", "format": "date"},
+		"expiryDate": {"type": "string", "format": "date"},
+		"expectedVersion": {"type": "integer", "minimum": 1}
+	}
+}`
+
+var requestSchemas = map[string]string{
+	"generatePolicy": `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["holderID", "countries"],
+	"properties": {
+		"holderID": {"type": "string", "minLength": 1},
+		"countries": {
+			"type": "array",
+			"minItems": 1,
+			"uniqueItems": true,
+			"items": {"type": "string", "minLength": 1, "pattern": "^[^~]*$"}
+		},
+		"effectiveDate": {"type": "string", "format": "date"},
+		"expiryDate": {"type": "string", "format": "date"}
+	}
+}`,
+	"assignTerms": termsSchema,
+	"modifyPolicy": termsSchema,
+	"castVote": `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["policyID", "carrier", "vote"],
+	"properties": {
+		"policyID": {"type": "string", "minLength": 1},
+		"carrier": {"type": "string", "minLength": 1},
+		"vote": {"type": "string", "enum": ["approve", "disapprove"]},
+		"reason": {"type": "string"},
+		"expectedVersion": {"type": "integer", "minimum": 1}
+	}
+}`,
+	"consentToModification": `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["policyID", "holderID", "vote"],
+	"properties": {
+		"policyID": {"type": "string", "minLength": 1},
+		"holderID": {"type": "string", "minLength": 1},
+		"vote": {"type": "string", "enum": ["approve", "disapprove"]},
+		"reason": {"type": "string"},
+		"expectedVersion": {"type": "integer", "minimum": 1}
+	}
+}`,
+	"withdrawModification": `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["policyID"],
+	"properties": {
+		"policyID": {"type": "string", "minLength": 1},
+		"expectedVersion": {"type": "integer", "minimum": 1}
+	}
+}`,
+	"renewPolicy": `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["policyID"],
+	"properties": {
+		"policyID": {"type": "string", "minLength": 1},
+		"effectiveDate": {"type": "string", "format": "date"},
+		"expiryDate": {"type": "string", "format": "date"}
+	}
+}`,
+	"confirmTerms": `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["policyID", "carrier"],
+	"properties": {
+		"policyID": {"type": "string", "minLength": 1},
+		"carrier": {"type": "string", "minLength": 1},
+		"expectedVersion": {"type": "integer", "minimum": 1}
+	}
+}`,
+	"cancelPolicy": `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["policyID", "reasonCode", "effectiveDate"],
+	"properties": {
+		"policyID": {"type": "string", "minLength": 1},
+		"reasonCode": {"type": "string", "enum": ["holderRequest", "nonPayment", "carrierWithdrawal", "subsidiaryClosed", "other"]},
+		"effectiveDate": {"type": "string", "format": "date"},
+		"country": {"type": "string"},
+		"expectedVersion": {"type": "integer", "minimum": 1}
+	}
+}`,
+	"endorsePolicy": `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["policyID", "action", "country"],
+	"properties": {
+		"policyID": {"type": "string", "minLength": 1},
+		"action": {"type": "string", "enum": ["addCountry", "removeCountry"]},
+		"country": {"type": "string", "minLength": 1, "pattern": "^[^~]*$"},
+		"expectedVersion": {"type": "integer", "minimum": 1}
+	}
+}`,
+	"listPolicies": `{
+	"$schema": "http://json-schema.org/draft-04/schema#",
+	"type": "object",
+	"additionalProperties": false,
+	"required": ["stage"],
+	"properties": {
+		"stage": {"type": "string", "enum": ["incomplete", "pending", "active", "expired", "cancelled"]},
+		"pageSize": {"type": "integer", "minimum": 1},
+		"bookmark": {"type": "string"},
+		"includeTotal": {"type": "boolean"},
+		"holderID": {"type": "string"},
+		"carrier": {"type": "string"},
+		"country": {"type": "string"},
+		"minPremium": {"type": "integer"},
+		"maxPremium": {"type": "integer"},
+		"minValue": {"type": "integer"},
+		"maxValue": {"type": "integer"}
+	}
+}`,
+}
+
+func getRequestSchema(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getRequestSchema")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	schema, found := requestSchemas[args[0]]
+	if !found {
+		return nil, newError(codeNotFound, "No request schema for function: " + args[0]).with("function", args[0])
+	}
+	return []byte(schema), nil
+}
+
+// parseExpectedVersion parses the optional positional expected version, which
+// is empty or a version number.
+func parseExpectedVersion(validation *ValidationError, arg string) int {
+	if arg == "" {
+		return 0
+	}
+	version, err := strconv.Atoi(arg)
+	if err != nil || version < 1 {
+		validation.add("expectedVersion", "must be a positive integer")
+		return 0
+	}
+	return version
+}
+
+// validateDate accepts an empty date or one in dateLayout.
+func validateDate(validation *ValidationError, field string, date string) {
+	if date == "" {
+		return
+	}
+	_, err := time.Parse(dateLayout, date)
+	if err != nil {
+		validation.add(field, "must be a date in the form YYYY-MM-DD")
+	}
+}
+
+func isJSONRequest(args []string) bool {
+	return len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{")
+}
+
+// decodeRequest decodes a JSON request document into request, rejecting
+// unknown fields and anything following the document.
+func decodeRequest(document string, request interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(document)))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(request)
+	if err != nil {
+		var validation ValidationError
+		validation.add("request", err.Error())
+		return validation.orNil()
+	}
+	_, err = decoder.Token()
+	if err != io.EOF {
+		var validation ValidationError
+		validation.add("request", "unexpected data after the request document")
+		return validation.orNil()
+	}
+	return nil
+}
+
+func parsePolicyRequest(args []string) (PolicyRequest, error) {
+	fmt.Println("Function: parsePolicyRequest")
+
+	var request PolicyRequest
+	if isJSONRequest(args) {
+		err := decodeRequest(args[0], &request)
+		if err != nil {
+			return request, err
+		}
+	} else {
+		if len(args) < 2 {
+			return request, argumentCountError("multiple arguments", len(args))
+		}
+		request.HolderID = args[0]
+		request.Countries = args[1:]
+	}
+
+	var validation ValidationError
+	if request.HolderID == "" {
+		validation.add("holderID", "must not be empty")
+	}
+	if len(request.Countries) == 0 {
+		validation.add("countries", "must list at least one country")
+	}
+	i := 0
+	for i < len(request.Countries) {
+		field := "countries[" + strconv.Itoa(i) + "]"
+		if request.Countries[i] == "" {
+			validation.add(field, "must not be empty")
+		}
+		if strings.Contains(request.Countries[i], "~") {
+			validation.add(field, "must not contain \"~\"")
+		}
+		j := 0
+		for j < i {
+			if request.Countries[j] == request.Countries[i] && request.Countries[i] != "" {
+				validation.add(field, "duplicates country " + request.Countries[i])
+				break
+			}
+			j = j + 1
+		}
+		i = i + 1
+	}
+	validateDate(&validation, "effectiveDate", request.EffectiveDate)
+	validateDate(&validation, "expiryDate", request.ExpiryDate)
+	return request, validation.orNil()
+}
+
+// args returns the request as the positional arguments it replaces, from
+// which the policy ID is derived.
+func (request PolicyRequest) args() []string {
+	return append([]string{request.HolderID}, request.Countries...)
+}
+
+func parseTermsRequest(args []string) (TermsRequest, error) {
+	fmt.Println("Function: parseTermsRequest")
+
+	var request TermsRequest
+	var validation ValidationError
+	if isJSONRequest(args) {
+		err := decodeRequest(args[0], &request)
+		if err != nil {
+			return request, err
+		}
+		if request.Premium == nil {
+			validation.add("premium", "is required")
+		}
+		if request.Value == nil {
+			validation.add("value", "is required")
+		}
+	} else {
+		if len(args) != 5 && len(args) != 6 {
+			return request, argumentCountError("5 or 6 arguments", len(args))
+		}
+		request.PolicyID = args[0]
+		request.CarrierID = args[1]
+		request.Country = args[2]
+		if len(args) == 6 {
+			request.ExpectedVersion = parseExpectedVersion(&validation, args[5])
+		}
+
+		premium, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			validation.add("premium", "must be an integer")
+		} else {
+			request.Premium = &premium
+		}
+		value, err := strconv.ParseInt(args[4], 10, 64)
+		if err != nil {
+			validation.add("value", "must be an integer")
+		} else {
+			request.Value = &value
+		}
+	}
+
+	if request.PolicyID == "" {
+		validation.add("policyID", "must not be empty")
+	}
+	if request.CarrierID == "" {
+		validation.add("carrier", "must not be empty")
+	}
+	if request.Country == "" {
+		validation.add("country", "must not be empty")
+	}
+	if request.Premium != nil && *request.Premium < 0 {
+		validation.add("premium", "must not be negative")
+	}
+	if request.Value != nil && *request.Value < 0 {
+		validation.add("value", "must not be negative")
+	}
+	if request.ExpectedVersion < 0 {
+		validation.add("expectedVersion", "must be a positive integer")
+	}
+	validateDate(&validation, "effectiveDate", request.EffectiveDate)
+	validateDate(&validation, "expiryDate", request.ExpiryDate)
+	return request, validation.orNil()
+}
+
+// args returns the terms as the positional arguments they replace, without
+// the policy ID, from which the terms ID is derived.
+func (request TermsRequest) args() []string {
+	return []string{request.CarrierID, request.Country, strconv.FormatInt(*request.Premium, 10), strconv.FormatInt(*request.Value, 10)}
+}
+
+func parseVoteRequest(args []string) (VoteRequest, error) {
+	fmt.Println("Function: parseVoteRequest")
+
+	var request VoteRequest
+	var validation ValidationError
+	if isJSONRequest(args) {
+		err := decodeRequest(args[0], &request)
+		if err != nil {
+			return request, err
+		}
+	} else {
+		if len(args) < 3 || len(args) > 5 {
+			return request, argumentCountError("three to five arguments", len(args))
+		}
+		request.PolicyID = args[0]
+		request.CarrierID = args[1]
+		request.Vote = args[2]
+		if len(args) > 3 {
+			request.Reason = args[3]
+		}
+		if len(args) == 5 {
+			request.ExpectedVersion = parseExpectedVersion(&validation, args[4])
+		}
+	}
+
+	if request.PolicyID == "" {
+		validation.add("policyID", "must not be empty")
+	}
+	if request.CarrierID == "" {
+		validation.add("carrier", "must not be empty")
+	}
+	if request.Vote != "approve" && request.Vote != "disapprove" {
+		validation.add("vote", "must be \"approve\" or \"disapprove\"")
+	}
+	if request.Vote == "disapprove" && strings.TrimSpace(request.Reason) == "" {
+		validation.add("reason", "is required to disapprove")
+	}
+	if request.ExpectedVersion < 0 {
+		validation.add("expectedVersion", "must be a positive integer")
+	}
+	return request, validation.orNil()
+}
+
+func parseConsentRequest(args []string) (ConsentRequest, error) {
+	fmt.Println("Function: parseConsentRequest")
+
+	var request ConsentRequest
+	var validation ValidationError
+	if isJSONRequest(args) {
+		err := decodeRequest(args[0], &request)
+		if err != nil {
+			return request, err
+		}
+	} else {
+		if len(args) < 3 || len(args) > 5 {
+			return request, argumentCountError("three to five arguments", len(args))
+		}
+		request.PolicyID = args[0]
+		request.HolderID = args[1]
+		request.Vote = args[2]
+		if len(args) > 3 {
+			request.Reason = args[3]
+		}
+		if len(args) == 5 {
+			request.ExpectedVersion = parseExpectedVersion(&validation, args[4])
+		}
+	}
+
+	if request.PolicyID == "" {
+		validation.add("policyID", "must not be empty")
+	}
+	if request.HolderID == "" {
+		validation.add("holderID", "must not be empty")
+	}
+	if request.Vote != "approve" && request.Vote != "disapprove" {
+		validation.add("vote", "must be \"approve\" or \"disapprove\"")
+	}
+	if request.Vote == "disapprove" && strings.TrimSpace(request.Reason) == "" {
+		validation.add("reason", "is required to disapprove")
+	}
+	if request.ExpectedVersion < 0 {
+		validation.add("expectedVersion", "must be a positive integer")
+	}
+	return request, validation.orNil()
+}
+
+func parseWithdrawalRequest(args []string) (WithdrawalRequest, error) {
+	fmt.Println("Function: parseWithdrawalRequest")
+
+	var request WithdrawalRequest
+	var validation ValidationError
+	if isJSONRequest(args) {
+		err := decodeRequest(args[0], &request)
+		if err != nil {
+			return request, err
+		}
+	} else {
+		if len(args) != 1 && len(args) != 2 {
+			return request, argumentCountError("1 or 2 arguments", len(args))
+		}
+		request.PolicyID = args[0]
+		if len(args) == 2 {
+			request.ExpectedVersion = parseExpectedVersion(&validation, args[1])
+		}
+	}
+
+	if request.PolicyID == "" {
+		validation.add("policyID", "must not be empty")
+	}
+	if request.ExpectedVersion < 0 {
+		validation.add("expectedVersion", "must be a positive integer")
+	}
+	return request, validation.orNil()
+}
+
+func parseRenewalRequest(args []string) (RenewalRequest, error) {
+	fmt.Println("Function: parseRenewalRequest")
+
+	var request RenewalRequest
+	if isJSONRequest(args) {
+		err := decodeRequest(args[0], &request)
+		if err != nil {
+			return request, err
+		}
+	} else {
+		if len(args) != 1 {
+			return request, argumentCountError("1 argument", len(args))
+		}
+		request.PolicyID = args[0]
+	}
+
+	var validation ValidationError
+	if request.PolicyID == "" {
+		validation.add("policyID", "must not be empty")
+	}
+	validateDate(&validation, "effectiveDate", request.EffectiveDate)
+	validateDate(&validation, "expiryDate", request.ExpiryDate)
+	return request, validation.orNil()
+}
+
+func parseConfirmRequest(args []string) (ConfirmRequest, error) {
+	fmt.Println("Function: parseConfirmRequest")
+
+	var request ConfirmRequest
+	var validation ValidationError
+	if isJSONRequest(args) {
+		err := decodeRequest(args[0], &request)
+		if err != nil {
+			return request, err
+		}
+	} else {
+		if len(args) != 2 && len(args) != 3 {
+			return request, argumentCountError("2 or 3 arguments", len(args))
+		}
+		request.PolicyID = args[0]
+		request.CarrierID = args[1]
+		if len(args) == 3 {
+			request.ExpectedVersion = parseExpectedVersion(&validation, args[2])
+		}
+	}
+
+	if request.PolicyID == "" {
+		validation.add("policyID", "must not be empty")
+	}
+	if request.CarrierID == "" {
+		validation.add("carrier", "must not be empty")
+	}
+	if request.ExpectedVersion < 0 {
+		validation.add("expectedVersion", "must be a positive integer")
+	}
+	return request, validation.orNil()
+}
+
+func parseCancelRequest(args []string) (CancelRequest, error) {
+	fmt.Println("Function: parseCancelRequest")
+
+	var request CancelRequest
+	var validation ValidationError
+	if isJSONRequest(args) {
+		err := decodeRequest(args[0], &request)
+		if err != nil {
+			return request, err
+		}
+	} else {
+		if len(args) < 3 || len(args) > 5 {
+			return request, argumentCountError("3 to 5 arguments", len(args))
+		}
+		request.PolicyID = args[0]
+		request.ReasonCode = args[1]
+		request.EffectiveDate = args[2]
+		if len(args) > 3 {
+			request.Country = args[3]
+		}
+		if len(args) == 5 {
+			request.ExpectedVersion = parseExpectedVersion(&validation, args[4])
+		}
+	}
+
+	if request.PolicyID == "" {
+		validation.add("policyID", "must not be empty")
+	}
+	reasonFound := false
+	i := 0
+	for i < len(cancellationReasons) {
+		if cancellationReasons[i] == request.ReasonCode {
+			reasonFound = true
+		}
+		i = i + 1
+	}
+	if !reasonFound {
+		validation.add("reasonCode", "must be one of " + strings.Join(cancellationReasons, ", "))
+	}
+	if request.EffectiveDate == "" {
+		validation.add("effectiveDate", "is required")
+	}
+	validateDate(&validation, "effectiveDate", request.EffectiveDate)
+	if request.ExpectedVersion < 0 {
+		validation.add("expectedVersion", "must be a positive integer")
+	}
+	return request, validation.orNil()
+}
+
+func parseListRequest(args []string) (ListRequest, error) {
+	fmt.Println("Function: parseListRequest")
+
+	var request ListRequest
+	if len(args) != 1 {
+		return request, argumentCountError("1 argument", len(args))
+	}
+	err := decodeRequest(args[0], &request)
+	if err != nil {
+		return request, err
+	}
+
+	var validation ValidationError
+	stageFound := false
+	i := 0
+	for i < len(policyStages) {
+		if policyStages[i] == request.Stage {
+			stageFound = true
+		}
+		i = i + 1
+	}
+	if !stageFound {
+		validation.add("stage", "must be one of " + strings.Join(policyStages, ", "))
+	}
+	if request.PageSize < 0 {
+		validation.add("pageSize", "must be positive")
+	}
+	if request.PageSize == 0 {
+		request.PageSize = defaultListPageSize
+	}
+	if request.MinPremium != nil && request.MaxPremium != nil && *request.MinPremium > *request.MaxPremium {
+		validation.add("maxPremium", "must not be less than minPremium")
+	}
+	if request.MinValue != nil && request.MaxValue != nil && *request.MinValue > *request.MaxValue {
+		validation.add("maxValue", "must not be less than minValue")
+	}
+	return request, validation.orNil()
+}
+
+func parseEndorsementRequest(args []string) (EndorsementRequest, error) {
+	fmt.Println("Function: parseEndorsementRequest")
+
+	var request EndorsementRequest
+	var validation ValidationError
+	if isJSONRequest(args) {
+		err := decodeRequest(args[0], &request)
+		if err != nil {
+			return request, err
+		}
+	} else {
+		if len(args) != 3 && len(args) != 4 {
+			return request, argumentCountError("3 or 4 arguments", len(args))
+		}
+		request.PolicyID = args[0]
+		request.Action = args[1]
+		request.Country = args[2]
+		if len(args) == 4 {
+			request.ExpectedVersion = parseExpectedVersion(&validation, args[3])
+		}
+	}
+
+	if request.PolicyID == "" {
+		validation.add("policyID", "must not be empty")
+	}
+	if request.Action != addCountryAction && request.Action != removeCountryAction {
+		validation.add("action", "must be " + addCountryAction + " or " + removeCountryAction)
+	}
+	if request.Country == "" {
+		validation.add("country", "must not be empty")
+	}
+	if strings.Contains(request.Country, "~") {
+		validation.add("country", "must not contain \"~\"")
+	}
+	if request.ExpectedVersion < 0 {
+		validation.add("expectedVersion", "must be a positive integer")
+	}
+	return request, validation.orNil()
+}
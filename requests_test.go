@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONRequests(t *testing.T) {
+	l := newTestLedger(t)
+
+	mustSucceed(t)(l.as(holderRole, "acme").invoke("generatePolicy", `{"holderID": "acme", "countries": ["US", "DE"]}`))
+	policy := onlyPolicy(t, l, "getIncompletePolicies")
+
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("assignTerms", `{"policyID": "` + policy.ID + `", "carrier": "carrierA", "country": "US", "premium": 100, "value": 1000}`))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("assignTerms", policy.ID, "carrierB", "DE", "200", "2000"))
+	mustSucceed(t)(l.as(carrierRole, "carrierA").invoke("castVote", `{"policyID": "` + policy.ID + `", "carrier": "carrierA", "vote": "approve"}`))
+	mustSucceed(t)(l.as(carrierRole, "carrierB").invoke("castVote", `{"policyID": "` + policy.ID + `", "carrier": "carrierB", "vote": "approve"}`))
+
+	active := onlyPolicy(t, l, "getActivePolicies")
+	if active.Terms[0].Premium != 100 || active.Terms[0].Value != 1000 {
+		t.Fatalf("unexpected terms from JSON request: %+v", active.Terms[0])
+	}
+}
+
+func TestJSONRequestValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"unknown field", []string{`{"policyID": "p", "carrier": "c", "country": "US", "premium": 1, "value": 1, "discount": 5}`}, []string{`unknown field "discount"`}},
+		{"negative premium", []string{`{"policyID": "p", "carrier": "c", "country": "US", "premium": -1, "value": 1}`}, []string{"premium: must not be negative"}},
+		{"empty country", []string{`{"policyID": "p", "carrier": "c", "country": "", "premium": 1, "value": 1}`}, []string{"country: must not be empty"}},
+		{"missing value", []string{`{"policyID": "p", "carrier": "c", "country": "US", "premium": 1}`}, []string{"value: is required"}},
+		{"trailing data", []string{`{"policyID": "p", "carrier": "c", "country": "US", "premium": 1, "value": 1} {}`}, []string{"unexpected data"}},
+		{"several fields", []string{"p", "", "", "1", "-2"}, []string{"carrier: must not be empty", "country: must not be empty", "value: must not be negative"}},
+		{"positional premium", []string{"p", "c", "US", "cheap", "1"}, []string{"premium: must be an integer"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTermsRequest(test.args)
+			if err == nil {
+				t.Fatal("request was accepted")
+			}
+			chaincodeError := asChaincodeError(err)
+			if chaincodeError.Code != codeInvalidArgument {
+				t.Fatalf("got error %v, want %s", err, codeInvalidArgument)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(chaincodeError.Message, want) {
+					t.Fatalf("got error %v, want one containing %q", err, want)
+				}
+			}
+		})
+	}
+
+	_, err := parsePolicyRequest([]string{`{"holderID": "acme", "countries": ["US", "", "US"]}`})
+	if err == nil || !strings.Contains(err.Error(), "countries[1]: must not be empty") || !strings.Contains(err.Error(), "countries[2]: duplicates country US") {
+		t.Fatalf("got error %v, want empty and duplicate country errors", err)
+	}
+}
+
+func TestGetRequestSchema(t *testing.T) {
+	l := newTestLedger(t)
+	schema := mustSucceed(t)(l.as(carrierRole, "carrierA").query("getRequestSchema", "assignTerms"))
+	if !strings.Contains(string(schema), `"additionalProperties": false`) {
+		t.Fatalf("unexpected schema: %s", schema)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A policy's Status is the stage it is stored in. Every change of status goes
+// through the transitions below; handlers update the policy and then call
+// applyTransition, which picks the first transition of the function whose
+// guard holds.
+
+type transition struct {
+	Function string
+	From string
+	To string
+	Guard string
+	// Retain leaves the record in the From stage in force, as an active
+	// policy stays in force while an endorsement of it is voted on.
+	Retain bool
+	// Prepare updates the policy before it is stored, as of the date of
+	// the transaction.
+	Prepare func(policy *Policy, date string)
+	Event string
+}
+
+var transitions = []transition{
+	{Function: "generatePolicy", From: "", To: incompleteStage, Event: policyCreatedEvent},
+	{Function: "assignTerms", From: incompleteStage, To: pendingStage, Guard: "complete", Prepare: openVotes, Event: policyPendingEvent},
+	{Function: "assignTerms", From: incompleteStage, To: incompleteStage},
+	{Function: "castVote", From: pendingStage, To: activeStage, Guard: "approved", Prepare: completeEndorsement, Event: policyActivatedEvent},
+	{Function: "castVote", From: pendingStage, To: activeStage, Guard: "removalRejected", Prepare: withdrawEndorsement, Event: endorsementRejectedEvent},
+	{Function: "castVote", From: pendingStage, To: incompleteStage, Guard: "voted", Prepare: reopenRejectedTerms, Event: policyRejectedEvent},
+	{Function: "castVote", From: pendingStage, To: pendingStage},
+	{Function: "renewPolicy", From: "", To: incompleteStage, Event: policyRenewedEvent},
+	{Function: "confirmTerms", From: incompleteStage, To: pendingStage, Guard: "complete", Prepare: openVotes, Event: policyPendingEvent},
+	{Function: "confirmTerms", From: incompleteStage, To: incompleteStage},
+	{Function: "modifyPolicy", From: activeStage, To: activeStage},
+	{Function: "castVote", From: activeStage, To: activeStage, Guard: "modificationApproved", Prepare: applyModification, Event: modificationApprovedEvent},
+	{Function: "castVote", From: activeStage, To: activeStage, Guard: "modificationRejected", Prepare: discardModification, Event: modificationRejectedEvent},
+	{Function: "castVote", From: activeStage, To: activeStage},
+	{Function: "consentToModification", From: activeStage, To: activeStage, Guard: "modificationApproved", Prepare: applyModification, Event: modificationApprovedEvent},
+	{Function: "consentToModification", From: activeStage, To: activeStage, Guard: "modificationRejected", Prepare: discardModification, Event: modificationRejectedEvent},
+	{Function: "consentToModification", From: activeStage, To: activeStage},
+	{Function: "withdrawModification", From: activeStage, To: activeStage, Prepare: discardModification, Event: modificationWithdrawnEvent},
+	{Function: "expirePolicies", From: activeStage, To: cancelledStage, Guard: "cancelled", Prepare: discardModification, Event: policyCancelledEvent},
+	{Function: "expirePolicies", From: activeStage, To: expiredStage, Prepare: discardModification, Event: policyExpiredEvent},
+	{Function: "cancelPolicy", From: activeStage, To: cancelledStage, Guard: "cancelled", Event: policyCancelledEvent},
+	{Function: "cancelPolicy", From: activeStage, To: activeStage},
+	{Function: "endorsePolicy", From: activeStage, To: activeStage, Guard: "approved", Prepare: completeEndorsement, Event: policyEndorsedEvent},
+	{Function: "endorsePolicy", From: activeStage, To: pendingStage, Guard: "complete", Retain: true},
+	{Function: "endorsePolicy", From: activeStage, To: incompleteStage, Retain: true},
+}
+
+// guards are the conditions a policy must meet, at the transaction date, to
+// take a transition.
+var guards = map[string]func(policy Policy, date string) bool{
+	"complete": policyComplete,
+	"voted": votesCast,
+	"approved": votesApproved,
+	"cancelled": cancellationDue,
+	"removalRejected": removalRejected,
+	"modificationRejected": modificationRejected,
+	"modificationApproved": modificationApproved,
+}
+
+func policyComplete(policy Policy, date string) bool {
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].ID == "" {
+			return false
+		}
+		i = i + 1
+	}
+	return true
+}
+
+func votesCast(policy Policy, date string) bool {
+	if len(policy.Votes) != len(policy.Terms) {
+		return false
+	}
+	i := 0
+	for i < len(policy.Votes) {
+		if policy.Votes[i].Vote == "" {
+			return false
+		}
+		i = i + 1
+	}
+	return true
+}
+
+func votesApproved(policy Policy, date string) bool {
+	if !votesCast(policy, date) {
+		return false
+	}
+	i := 0
+	for i < len(policy.Votes) {
+		if policy.Votes[i].Vote != "approve" {
+			return false
+		}
+		i = i + 1
+	}
+	return true
+}
+
+func termsCancelled(policy Policy) bool {
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].CancellationDate == "" {
+			return false
+		}
+		i = i + 1
+	}
+	return true
+}
+
+// cancellationDue reports whether every terms of policy has been cancelled
+// from date or earlier.
+func cancellationDue(policy Policy, date string) bool {
+	i := 0
+	for i < len(policy.Terms) {
+		if policy.Terms[i].CancellationDate == "" || policy.Terms[i].CancellationDate > date {
+			return false
+		}
+		i = i + 1
+	}
+	return true
+}
+
+func isStage(status string) bool {
+	i := 0
+	for i < len(policyStages) {
+		if policyStages[i] == status {
+			return true
+		}
+		i = i + 1
+	}
+	return false
+}
+
+// resetVotes clears the votes of a policy entering the pending stage.
+func resetVotes(policy *Policy) {
+	policy.Votes = make([]Approval, len(policy.Terms))
+}
+
+// openVotes prepares the votes of a policy entering the pending stage from
+// quoting. Approvals of terms kept after a rejection still stand.
+func openVotes(policy *Policy, date string) {
+	if len(policy.Votes) != len(policy.Terms) {
+		resetVotes(policy)
+	}
+}
+
+// reopenRejectedTerms removes the disapproved terms of a policy, keeping the
+// country to be quoted again, and keeps the approvals of the other terms.
+func reopenRejectedTerms(policy *Policy, date string) {
+	i := 0
+	for i < len(policy.Votes) {
+		if policy.Votes[i].Vote != "approve" {
+			policy.Terms[i] = CarrierTerms{Country: policy.Terms[i].Country}
+			policy.Votes[i] = Approval{}
+		}
+		i = i + 1
+	}
+}
+
+// applyTransition moves policy out of its current status through the first
+// transition of function whose guard it meets: it stores the policy as a new
+// version in its new stage, records its history and queues the transition's
+// event for the carriers of the policy before it was prepared.
+// previousTerms are the terms of the policy before the handler changed them.
+func applyTransition(stub LedgerStub, function string, previousTerms []CarrierTerms, policy Policy) (Policy, error) {
+	fmt.Println("Function: applyTransition (" + function + ")")
+
+	today, err := txDate(stub)
+	if err != nil {
+		return policy, err
+	}
+
+	from := policy.Status
+	i := 0
+	for i < len(transitions) {
+		t := transitions[i]
+		if t.Function == function && t.From == from && (t.Guard == "" || guards[t.Guard](policy, today.Format(dateLayout))) {
+			carriers := policyCarriers(policy)
+			policy.Status = t.To
+			if t.Prepare != nil {
+				t.Prepare(&policy, today.Format(dateLayout))
+			}
+
+			err = storePolicyVersion(stub, &policy)
+			if err != nil {
+				return policy, err
+			}
+
+			if isStage(from) && from != t.To && !t.Retain {
+				err = deletePolicy(stub, from, policy.ID)
+				if err != nil {
+					return policy, err
+				}
+			}
+			if isStage(t.To) {
+				err = writePolicy(stub, t.To, policy)
+				if err != nil {
+					return policy, err
+				}
+			}
+
+			err = recordHistory(stub, function, from, t.To, previousTerms, policy)
+			if err != nil {
+				return policy, err
+			}
+			if t.Event != "" {
+				queueEvent(stub, t.Event, t.To, policy, carriers, policy.Countries)
+			}
+			fmt.Println("policy " + policy.ID + " moved from " + from + " to " + t.To)
+			return policy, nil
+		}
+		i = i + 1
+	}
+
+	return policy, newError(codeWrongStage, function + " may not be applied to a " + from + " policy").with("policyID", policy.ID).with("stage", from)
+}
+
+// getStateMachine returns the policy state machine as a Graphviz DOT graph.
+func getStateMachine(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getStateMachine")
+
+	lines := []string{"digraph policy {", "\t\"\" [shape=point];"}
+	i := 0
+	for i < len(transitions) {
+		t := transitions[i]
+		label := t.Function
+		if t.Guard != "" {
+			label = label + " [" + t.Guard + "]"
+		}
+		if t.Retain {
+			label = label + " (retains " + t.From + ")"
+		}
+		if t.Event != "" {
+			label = label + " / " + t.Event
+		}
+		lines = append(lines, "\t\"" + t.From + "\" -> \"" + t.To + "\" [label=\"" + label + "\"];")
+		i = i + 1
+	}
+	lines = append(lines, "}")
+
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// migratePolicyStatus sets the status of each stored policy that predates
+// the Status field to the stage it is stored in.
+func migratePolicyStatus(stub LedgerStub) error {
+	fmt.Println("Function: migratePolicyStatus")
+
+	i := 0
+	for i < len(policyStages) {
+		policies, err := readPolicies(stub, policyStages[i])
+		if err != nil {
+			return err
+		}
+
+		j := 0
+		for j < len(policies.Catalog) {
+			if policies.Catalog[j].Status == "" {
+				policies.Catalog[j].Status = policyStages[i]
+				err = writePolicy(stub, policyStages[i], policies.Catalog[j])
+				if err != nil {
+					return err
+				}
+			}
+			j = j + 1
+		}
+		i = i + 1
+	}
+	return nil
+}
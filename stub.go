@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// LedgerStub is the part of the chaincode stub that the handlers depend on:
+// state access, range queries, transaction details, events and the caller's
+// certificate attributes. shimLedger adapts the Fabric stub to it, and the
+// tests run the handlers against an in-memory implementation.
+type LedgerStub interface {
+	GetState(key string) ([]byte, error)
+	PutState(key string, value []byte) error
+	DelState(key string) error
+	RangeQueryState(startKey string, endKey string) (StateIterator, error)
+	GetTxID() string
+	GetTxTimestamp() (*timestamp.Timestamp, error)
+	SetEvent(name string, payload []byte) error
+	ReadCertAttribute(attributeName string) ([]byte, error)
+}
+
+// StateIterator walks the key/value pairs returned by a range query.
+type StateIterator interface {
+	HasNext() bool
+	Next() (string, []byte, error)
+	Close() error
+}
+
+type shimLedger struct {
+	shim.ChaincodeStubInterface
+}
+
+// RangeQueryState returns the keys from startKey up to, but not including,
+// endKey.
+func (l shimLedger) RangeQueryState(startKey string, endKey string) (StateIterator, error) {
+	iter, err := l.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return shimIterator{iter}, nil
+}
+
+// ReadCertAttribute reads an attribute of the caller's enrollment
+// certificate, as issued by the Fabric CA.
+func (l shimLedger) ReadCertAttribute(attributeName string) ([]byte, error) {
+	value, found, err := cid.GetAttributeValue(l.ChaincodeStubInterface, attributeName)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Caller certificate has no attribute: " + attributeName)
+	}
+	return []byte(value), nil
+}
+
+type shimIterator struct {
+	shim.StateQueryIteratorInterface
+}
+
+func (i shimIterator) Next() (string, []byte, error) {
+	kv, err := i.StateQueryIteratorInterface.Next()
+	if err != nil {
+		return "", nil, err
+	}
+	return kv.Key, kv.Value, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Every transition of a policy stores a new version of it, numbered from one.
+// Versions are written once under policyVersion~<policyID>~<version> and never
+// changed; policyVersionHead~<policyID> holds the latest version number. The
+// version of a policy under endorsement may run ahead of its active record.
+var policyVersionKeyPrefix = "policyVersion~"
+var policyVersionHeadKeyPrefix = "policyVersionHead~"
+
+func policyVersionKey(policyID string, version int) string {
+	return policyVersionKeyPrefix + policyID + "~" + fmt.Sprintf("%010d", version)
+}
+
+func policyVersionHeadKey(policyID string) string {
+	return policyVersionHeadKeyPrefix + policyID
+}
+
+func readLatestVersion(stub LedgerStub, policyID string) (int, error) {
+	fmt.Println("Function: readLatestVersion")
+
+	versionAsBytes, err := stub.GetState(policyVersionHeadKey(policyID))
+	if err != nil {
+		return 0, err
+	}
+	if versionAsBytes == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(versionAsBytes))
+}
+
+// storePolicyVersion numbers policy as the next version of it and stores that
+// version.
+func storePolicyVersion(stub LedgerStub, policy *Policy) error {
+	fmt.Println("Function: storePolicyVersion")
+
+	latest, err := readLatestVersion(stub, policy.ID)
+	if err != nil {
+		return err
+	}
+	policy.Version = latest + 1
+
+	key := policyVersionKey(policy.ID, policy.Version)
+	existingAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	if existingAsBytes != nil {
+		return newError(codeInternal, "Version " + strconv.Itoa(policy.Version) + " of policy " + policy.ID + " is already stored").with("policyID", policy.ID).with("version", policy.Version)
+	}
+
+	policyAsBytes, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	err = write(stub, key, policyAsBytes)
+	if err != nil {
+		return err
+	}
+	return write(stub, policyVersionHeadKey(policy.ID), []byte(strconv.Itoa(policy.Version)))
+}
+
+// checkExpectedVersion rejects a change to policy made from a stale read of
+// it. An expected version of zero is not checked.
+func checkExpectedVersion(policy Policy, expected int) error {
+	if expected == 0 || policy.Version == expected {
+		return nil
+	}
+	return newError(codeVersionConflict, "Policy " + policy.ID + " is at version " + strconv.Itoa(policy.Version) + ", not " + strconv.Itoa(expected)).with("policyID", policy.ID).with("version", policy.Version).with("expectedVersion", expected)
+}
+
+func readPolicyVersion(stub LedgerStub, policyID string, version int) (Policy, error) {
+	fmt.Println("Function: readPolicyVersion")
+
+	var policy Policy
+	policyAsBytes, err := stub.GetState(policyVersionKey(policyID, version))
+	if err != nil {
+		return policy, err
+	}
+	if policyAsBytes == nil {
+		return policy, newError(codeNotFound, "No version " + strconv.Itoa(version) + " of policy " + policyID).with("policyID", policyID).with("version", version)
+	}
+
+	err = json.Unmarshal(policyAsBytes, &policy)
+	return policy, err
+}
+
+func parseVersion(arg string, field string) (int, error) {
+	version, err := strconv.Atoi(arg)
+	if err != nil || version < 1 {
+		return 0, newError(codeInvalidArgument, "Invalid version: " + arg).with("field", field)
+	}
+	return version, nil
+}
+
+// getPolicyVersion returns the given version of a policy.
+func getPolicyVersion(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getPolicyVersion")
+
+	if len(args) != 2 {
+		return nil, argumentCountError("2 arguments", len(args))
+	}
+
+	version, err := parseVersion(args[1], "version")
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := readPolicyVersion(stub, args[0], version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, policy.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(policy)
+}
+
+// termsValues returns the carrier, premium and value of terms, or nil for an
+// unquoted country.
+func termsValues(terms CarrierTerms) *TermsValues {
+	if terms.ID == "" {
+		return nil
+	}
+	return &TermsValues{CarrierID: terms.CarrierID, Premium: terms.Premium, Value: terms.Value}
+}
+
+// diffPolicyVersions lists, per country, the changes of carrier, premium and
+// value between two versions of a policy. args are the policy ID and the
+// versions to compare from and to.
+func diffPolicyVersions(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: diffPolicyVersions")
+
+	if len(args) != 3 {
+		return nil, argumentCountError("3 arguments", len(args))
+	}
+
+	fromVersion, err := parseVersion(args[1], "from")
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := parseVersion(args[2], "to")
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := readPolicyVersion(stub, args[0], fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := readPolicyVersion(stub, args[0], toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkActingAs(stub, holderRole, to.HolderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var diff VersionDiff
+	diff.PolicyID = to.ID
+	diff.From = fromVersion
+	diff.To = toVersion
+	diff.Countries = make([]CountryDiff, 0)
+
+	// Countries are compared by name, as endorsements move their terms
+	countries := append(append([]string{}, from.Countries...), to.Countries...)
+	seen := make(map[string]bool)
+	i := 0
+	for i < len(countries) {
+		country := countries[i]
+		if seen[country] {
+			i = i + 1
+			continue
+		}
+		seen[country] = true
+
+		var change CountryDiff
+		change.Country = country
+		j := 0
+		for j < len(from.Terms) {
+			if from.Terms[j].Country == country {
+				change.Previous = termsValues(from.Terms[j])
+			}
+			j = j + 1
+		}
+		j = 0
+		for j < len(to.Terms) {
+			if to.Terms[j].Country == country {
+				change.Current = termsValues(to.Terms[j])
+			}
+			j = j + 1
+		}
+
+		changed := change.Previous != nil || change.Current != nil
+		if change.Previous != nil && change.Current != nil && *change.Previous == *change.Current {
+			changed = false
+		}
+		if changed {
+			diff.Countries = append(diff.Countries, change)
+		}
+		i = i + 1
+	}
+
+	return json.Marshal(diff)
+}
+
+// migratePolicyVersions stores the first version of each stored policy that
+// predates versioning.
+func migratePolicyVersions(stub LedgerStub) error {
+	fmt.Println("Function: migratePolicyVersions")
+
+	i := 0
+	for i < len(policyStages) {
+		policies, err := readPolicies(stub, policyStages[i])
+		if err != nil {
+			return err
+		}
+
+		j := 0
+		for j < len(policies.Catalog) {
+			if policies.Catalog[j].Version == 0 {
+				err = storePolicyVersion(stub, &policies.Catalog[j])
+				if err != nil {
+					return err
+				}
+				err = writePolicy(stub, policyStages[i], policies.Catalog[j])
+				if err != nil {
+					return err
+				}
+			}
+			j = j + 1
+		}
+		i = i + 1
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Carriers find their work through two indexes kept in step with the policy
+// records by updatePolicyIndexes:
+//   openQuote~<country>~<policyID>    an incomplete policy has no terms for country
+//   pendingVote~<carrierID>~<policyID> a pending policy, or the modification of
+//                                      an active one, awaits a vote by carrier
+var openQuoteKeyPrefix = "openQuote~"
+var pendingVoteKeyPrefix = "pendingVote~"
+
+func openQuoteKey(country string, policyID string) string {
+	return openQuoteKeyPrefix + country + "~" + policyID
+}
+
+func pendingVoteKey(carrierID string, policyID string) string {
+	return pendingVoteKeyPrefix + carrierID + "~" + policyID
+}
+
+// workQueueKeys returns the index keys for a policy held in stage.
+func workQueueKeys(stage string, policy Policy) []string {
+	keys := make([]string, 0)
+
+	i := 0
+	for i < len(policy.Terms) {
+		if stage == incompleteStage && policy.Terms[i].ID == "" {
+			keys = append(keys, openQuoteKey(policy.Terms[i].Country, policy.ID))
+		}
+		if stage == pendingStage && i < len(policy.Votes) && policy.Votes[i].Vote == "" {
+			keys = append(keys, pendingVoteKey(policy.Terms[i].CarrierID, policy.ID))
+		}
+		if stage == activeStage && policy.Modification != nil && policy.Modification.Votes[i].Vote == "" {
+			keys = append(keys, pendingVoteKey(policy.Terms[i].CarrierID, policy.ID))
+		}
+		i = i + 1
+	}
+	return keys
+}
+
+// readIndexedPolicies appends to policies those named by the index keys
+// beginning with prefix, skipping any already seen.
+func readIndexedPolicies(stub LedgerStub, prefix string, policies *AllPolicies, seen map[string]bool) error {
+	fmt.Println("Function: readIndexedPolicies (" + prefix + ")")
+
+	startKey, endKey := prefixRange(prefix)
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		_, policyIDAsBytes, err := iter.Next()
+		if err != nil {
+			return err
+		}
+
+		policyID := string(policyIDAsBytes)
+		if seen[policyID] {
+			continue
+		}
+		seen[policyID] = true
+
+		policy, err := readAnyPolicy(stub, policyID)
+		if err != nil {
+			return err
+		}
+		policies.Catalog = append(policies.Catalog, policy)
+	}
+	return nil
+}
+
+// getOpenQuoteRequests returns the incomplete policies that still need terms
+// in a country the carrier is licensed for.
+func getOpenQuoteRequests(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getOpenQuoteRequests")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	carrierID := args[0]
+	err := checkActingAs(stub, carrierRole, carrierID)
+	if err != nil {
+		return nil, err
+	}
+
+	carrier, err := readCarrier(stub, carrierID)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies AllPolicies
+	policies.Catalog = make([]Policy, 0)
+	seen := make(map[string]bool)
+
+	i := 0
+	for i < len(carrier.LicensedCountries) {
+		err = readIndexedPolicies(stub, openQuoteKey(carrier.LicensedCountries[i], ""), &policies, seen)
+		if err != nil {
+			return nil, err
+		}
+		i = i + 1
+	}
+
+	return json.Marshal(policies)
+}
+
+// getPendingVotes returns the pending policies, and the active policies under
+// modification, on which the carrier has terms it has not yet voted on.
+func getPendingVotes(stub LedgerStub, args []string) ([]byte, error) {
+	fmt.Println("Function: getPendingVotes")
+
+	if len(args) != 1 {
+		return nil, argumentCountError("1 argument", len(args))
+	}
+
+	carrierID := args[0]
+	err := checkActingAs(stub, carrierRole, carrierID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = readCarrier(stub, carrierID)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies AllPolicies
+	policies.Catalog = make([]Policy, 0)
+
+	err = readIndexedPolicies(stub, pendingVoteKey(carrierID, ""), &policies, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(policies)
+}
+
+// migrateWorkQueues builds the work queue indexes for the incomplete and
+// pending policies already on the ledger.
+func migrateWorkQueues(stub LedgerStub) error {
+	fmt.Println("Function: migrateWorkQueues")
+
+	stages := []string{incompleteStage, pendingStage}
+
+	i := 0
+	for i < len(stages) {
+		policies, err := readPolicies(stub, stages[i])
+		if err != nil {
+			return err
+		}
+
+		j := 0
+		for j < len(policies.Catalog) {
+			err = updatePolicyIndexes(stub, stages[i], nil, &policies.Catalog[j])
+			if err != nil {
+				return err
+			}
+			j = j + 1
+		}
+		i = i + 1
+	}
+	return nil
+}